@@ -0,0 +1,194 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+// Package cgroups places the processes of a nabla container, i.e. the
+// nabla-run monitors of its unikernels, in a cgroup of their own.
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	"golang.org/x/sys/unix"
+)
+
+const unifiedMountpoint = "/sys/fs/cgroup"
+
+// FreezerState is the state of the freezer of a cgroup
+type FreezerState string
+
+const (
+	Undefined FreezerState = ""
+	Frozen    FreezerState = "FROZEN"
+	Thawed    FreezerState = "THAWED"
+)
+
+// Manager manages the cgroup of a container.
+type Manager interface {
+	// Apply creates the cgroup, if needed, and moves the process pid in it.
+	Apply(pid int) error
+
+	// Set applies the resource limits r to the cgroup.
+	Set(r *configs.Resources) error
+
+	// Freeze freezes or thaws all the processes of the cgroup.
+	Freeze(state FreezerState) error
+
+	// GetPids returns the pids of the processes in the cgroup.
+	GetPids() ([]int, error)
+
+	// GetPaths returns the directories of the cgroup, keyed by subsystem.
+	// They are saved in the state of the container.
+	GetPaths() map[string]string
+
+	// Destroy removes the cgroup, all its processes must have exited.
+	Destroy() error
+}
+
+// NewManager returns the manager of the cgroup path, relative to the root
+// of the cgroup hierarchies, for the cgroup version of the host. paths are
+// the directories returned by GetPaths when loading an existing container.
+func NewManager(path string, paths map[string]string) Manager {
+	if paths == nil {
+		paths = map[string]string{}
+	}
+	if IsCgroup2UnifiedMode() {
+		return &unifiedManager{path: path, paths: paths}
+	}
+	return &legacyManager{path: path, paths: paths}
+}
+
+// IsCgroup2UnifiedMode returns whether the host only has the cgroup v2
+// hierarchy mounted.
+func IsCgroup2UnifiedMode() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(unifiedMountpoint, &st); err != nil {
+		return false
+	}
+	return st.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// findCgroupMountpoint returns the mountpoint of the cgroup v1 hierarchy of
+// subsystem, or an empty string if it is not mounted.
+func findCgroupMountpoint(subsystem string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The mount point is field 5 and the super options are the last
+		// field, see proc(5).
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) < 10 || fields[len(fields)-3] != "cgroup" {
+			continue
+		}
+		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
+			if opt == subsystem {
+				return fields[4], nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
+
+// cgroupFile is a value to write to a file of the cgroup of a subsystem.
+type cgroupFile struct {
+	subsystem string
+	name      string
+	value     string
+}
+
+// pidsMax returns the value of pids.max for the limit, <= 0 disables it.
+func pidsMax(limit int64) string {
+	if limit <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
+
+func writeFile(dir, file, data string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0700)
+}
+
+func readFile(dir, file string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, file))
+	return string(data), err
+}
+
+// readPids returns the pids listed in the cgroup.procs of dir.
+func readPids(dir string) ([]int, error) {
+	data, err := readFile(dir, "cgroup.procs")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, f := range strings.Fields(data) {
+		pid, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q in cgroup.procs of %s", f, dir)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// removePaths removes the cgroup directories, retrying with an increasing
+// delay since the kernel only allows it once the last process is gone.
+func removePaths(paths map[string]string) error {
+	delay := 10 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		if i != 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		for s, p := range paths {
+			if err := os.Remove(p); err == nil || os.IsNotExist(err) {
+				delete(paths, s)
+			}
+		}
+		if len(paths) == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to remove cgroup paths: %v", paths)
+}
+
+// waitFreezer polls check until it reports the cgroup reached the requested
+// state, freezing may take a while if a process is in an uninterruptible
+// sleep.
+func waitFreezer(check func() (bool, error)) error {
+	for i := 0; i < 1000; i++ {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout waiting for the cgroup freezer")
+}
@@ -0,0 +1,183 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+)
+
+// subsystems are the cgroup v1 hierarchies a container is placed in.
+var subsystems = []string{"freezer", "cpu", "cpuset", "pids", "memory"}
+
+// legacyManager manages a cgroup in the cgroup v1 hierarchies.
+type legacyManager struct {
+	path  string
+	paths map[string]string
+}
+
+func (m *legacyManager) Apply(pid int) error {
+	for _, sys := range subsystems {
+		mnt, err := findCgroupMountpoint(sys)
+		if err != nil {
+			return err
+		}
+		if mnt == "" {
+			// The subsystem is not available on this host
+			continue
+		}
+		dir := filepath.Join(mnt, m.path)
+		if sys == "cpuset" {
+			err = initCpuset(mnt, dir)
+		} else {
+			err = os.MkdirAll(dir, 0755)
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFile(dir, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+			return err
+		}
+		m.paths[sys] = dir
+	}
+	return nil
+}
+
+// initCpuset creates dir and its missing parents up to the mountpoint mnt,
+// copying the cpus and mems of the parent into each of them since a cpuset
+// without them can not hold any process.
+func initCpuset(mnt, dir string) error {
+	if dir == mnt || dir == "/" {
+		return nil
+	}
+	parent := filepath.Dir(dir)
+	if err := initCpuset(mnt, parent); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		cur, err := readFile(dir, file)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(cur) != "" {
+			continue
+		}
+		val, err := readFile(parent, file)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(dir, file, strings.TrimSpace(val)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set writes the resources to the cgroup. The resources of the subsystems
+// that are not available on this host are skipped, as they are in Apply.
+func (m *legacyManager) Set(r *configs.Resources) error {
+	if r == nil {
+		return nil
+	}
+	var files []cgroupFile
+	if r.CpuShares != 0 {
+		files = append(files, cgroupFile{"cpu", "cpu.shares", strconv.FormatUint(r.CpuShares, 10)})
+	}
+	// The period has to be set first, the quota is validated against it
+	if r.CpuPeriod != 0 {
+		files = append(files, cgroupFile{"cpu", "cpu.cfs_period_us", strconv.FormatUint(r.CpuPeriod, 10)})
+	}
+	if r.CpuQuota != 0 {
+		files = append(files, cgroupFile{"cpu", "cpu.cfs_quota_us", strconv.FormatInt(r.CpuQuota, 10)})
+	}
+	if r.CpusetCpus != "" {
+		files = append(files, cgroupFile{"cpuset", "cpuset.cpus", r.CpusetCpus})
+	}
+	if r.CpusetMems != "" {
+		files = append(files, cgroupFile{"cpuset", "cpuset.mems", r.CpusetMems})
+	}
+	if r.PidsLimit != 0 {
+		files = append(files, cgroupFile{"pids", "pids.max", pidsMax(r.PidsLimit)})
+	}
+	if r.Memory != 0 {
+		files = append(files, cgroupFile{"memory", "memory.limit_in_bytes", strconv.FormatInt(r.Memory, 10)})
+	}
+
+	for _, f := range files {
+		dir, ok := m.paths[f.subsystem]
+		if !ok {
+			logrus.Warnf("%s cgroup is not available, %s is not set", f.subsystem, f.name)
+			continue
+		}
+		if err := writeFile(dir, f.name, f.value); err != nil {
+			return fmt.Errorf("failed to write %s to %s: %v", f.value, f.name, err)
+		}
+	}
+	return nil
+}
+
+func (m *legacyManager) Freeze(state FreezerState) error {
+	dir, ok := m.paths["freezer"]
+	if !ok {
+		return fmt.Errorf("freezer cgroup is not available")
+	}
+	if err := writeFile(dir, "freezer.state", string(state)); err != nil {
+		return err
+	}
+	return waitFreezer(func() (bool, error) {
+		cur, err := readFile(dir, "freezer.state")
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(cur) == string(state), nil
+	})
+}
+
+func (m *legacyManager) GetPids() ([]int, error) {
+	// The processes are the same in all the hierarchies
+	for _, sys := range subsystems {
+		if dir, ok := m.paths[sys]; ok {
+			return readPids(dir)
+		}
+	}
+	return nil, nil
+}
+
+func (m *legacyManager) GetPaths() map[string]string {
+	paths := make(map[string]string, len(m.paths))
+	for s, p := range m.paths {
+		paths[s] = p
+	}
+	return paths
+}
+
+func (m *legacyManager) Destroy() error {
+	if err := removePaths(m.paths); err != nil {
+		return err
+	}
+	m.paths = map[string]string{}
+	return nil
+}
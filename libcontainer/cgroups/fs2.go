@@ -0,0 +1,222 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package cgroups
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	"github.com/sirupsen/logrus"
+)
+
+// controllers are the cgroup v2 controllers enabled for a container.
+var controllers = []string{"cpu", "cpuset", "pids", "memory"}
+
+// unifiedManager manages a cgroup in the cgroup v2 hierarchy. Its only
+// directory is saved with an empty subsystem name.
+type unifiedManager struct {
+	path  string
+	paths map[string]string
+}
+
+func (m *unifiedManager) Apply(pid int) error {
+	dir := filepath.Join(unifiedMountpoint, m.path)
+	if err := createCgroup(dir); err != nil {
+		return err
+	}
+	if err := writeFile(dir, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return err
+	}
+	m.paths[""] = dir
+	return nil
+}
+
+// createCgroup creates dir and its missing parents, enabling the
+// controllers available in each parent for its children.
+func createCgroup(dir string) error {
+	if dir == unifiedMountpoint || dir == "/" {
+		return nil
+	}
+	parent := filepath.Dir(dir)
+	if err := createCgroup(parent); err != nil {
+		return err
+	}
+	if err := enableControllers(parent); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+func enableControllers(dir string) error {
+	available, err := readFile(dir, "cgroup.controllers")
+	if err != nil {
+		return err
+	}
+	enabled, err := readFile(dir, "cgroup.subtree_control")
+	if err != nil {
+		return err
+	}
+	var enable []string
+	for _, c := range controllers {
+		if hasField(available, c) && !hasField(enabled, c) {
+			enable = append(enable, "+"+c)
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	if err := writeFile(dir, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return fmt.Errorf("failed to enable controllers %v in %s: %v", enable, dir, err)
+	}
+	return nil
+}
+
+func hasField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Set writes the resources to the cgroup. The resources of the controllers
+// that are not available on this host are skipped, as they are in Apply.
+func (m *unifiedManager) Set(r *configs.Resources) error {
+	if r == nil {
+		return nil
+	}
+	dir, ok := m.paths[""]
+	if !ok {
+		return fmt.Errorf("cgroup is not available")
+	}
+
+	var files []cgroupFile
+	if r.CpuShares != 0 {
+		files = append(files, cgroupFile{"cpu", "cpu.weight", strconv.FormatUint(sharesToWeight(r.CpuShares), 10)})
+	}
+	if r.CpuQuota != 0 || r.CpuPeriod != 0 {
+		max := "max"
+		if r.CpuQuota > 0 {
+			max = strconv.FormatInt(r.CpuQuota, 10)
+		}
+		if r.CpuPeriod != 0 {
+			max += " " + strconv.FormatUint(r.CpuPeriod, 10)
+		}
+		files = append(files, cgroupFile{"cpu", "cpu.max", max})
+	}
+	if r.CpusetCpus != "" {
+		files = append(files, cgroupFile{"cpuset", "cpuset.cpus", r.CpusetCpus})
+	}
+	if r.CpusetMems != "" {
+		files = append(files, cgroupFile{"cpuset", "cpuset.mems", r.CpusetMems})
+	}
+	if r.PidsLimit != 0 {
+		files = append(files, cgroupFile{"pids", "pids.max", pidsMax(r.PidsLimit)})
+	}
+	if r.Memory != 0 {
+		files = append(files, cgroupFile{"memory", "memory.max", strconv.FormatInt(r.Memory, 10)})
+	}
+
+	for _, f := range files {
+		if err := writeFile(dir, f.name, f.value); err != nil {
+			if os.IsNotExist(err) {
+				logrus.Warnf("%s controller is not available, %s is not set", f.subsystem, f.name)
+				continue
+			}
+			return fmt.Errorf("failed to write %s to %s: %v", f.value, f.name, err)
+		}
+	}
+	return nil
+}
+
+// sharesToWeight converts the cgroup v1 cpu shares, in [2, 262144], to the
+// cgroup v2 cpu weight, in [1, 10000].
+func sharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+func (m *unifiedManager) Freeze(state FreezerState) error {
+	dir, ok := m.paths[""]
+	if !ok {
+		return fmt.Errorf("cgroup is not available")
+	}
+	var want string
+	switch state {
+	case Frozen:
+		want = "1"
+	case Thawed:
+		want = "0"
+	default:
+		return fmt.Errorf("invalid freezer state %q", state)
+	}
+	if err := writeFile(dir, "cgroup.freeze", want); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("cgroup v2 freezer is not supported by the kernel")
+		}
+		return err
+	}
+	return waitFreezer(func() (bool, error) {
+		events, err := readFile(dir, "cgroup.events")
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Split(events, "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "frozen" {
+				return fields[1] == want, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+func (m *unifiedManager) GetPids() ([]int, error) {
+	dir, ok := m.paths[""]
+	if !ok {
+		return nil, nil
+	}
+	return readPids(dir)
+}
+
+func (m *unifiedManager) GetPaths() map[string]string {
+	paths := make(map[string]string, len(m.paths))
+	for s, p := range m.paths {
+		paths[s] = p
+	}
+	return paths
+}
+
+func (m *unifiedManager) Destroy() error {
+	if err := removePaths(m.paths); err != nil {
+		return err
+	}
+	m.paths = map[string]string{}
+	return nil
+}
@@ -28,6 +28,18 @@ type Config struct {
 	// Mounts specify source and destination paths that will be copied
 	// inside the container's rootfs.
 	Mounts []spec.Mount `json:"mounts,omitempty"`
+
+	// Readonly is set if the root filesystem of the container must be
+	// read-only.
+	Readonly bool `json:"readonly,omitempty"`
+
+	// CgroupsPath is the cgroup of the container, relative to the root of
+	// the cgroup hierarchies when absolute, or to the runtime cgroup
+	// otherwise. Empty for the default cgroup.
+	CgroupsPath string `json:"cgroups_path,omitempty"`
+
+	// Resources are the limits of the cgroup of the container.
+	Resources *Resources `json:"resources,omitempty"`
 }
 
 // HostUID returns the UID to run the nabla container as. Default is root.
@@ -2,3 +2,7 @@ package configs
 
 //ContainerMemoryMinimum is the size in MB if none is explicitly passed from docker cli.
 const ContainerMemoryMinimum = 512
+
+//ContainerMemoryOverhead is the size in MB allowed on top of the guest memory
+//for the nabla-run monitor in the memory limit of the container cgroup.
+const ContainerMemoryOverhead = 64
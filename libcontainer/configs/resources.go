@@ -0,0 +1,33 @@
+package configs
+
+// Resources are the cgroup limits of the processes of a container, i.e. the
+// nabla-run monitors of its unikernels.
+type Resources struct {
+	// CPU shares (relative weight vs. other containers)
+	CpuShares uint64 `json:"cpu_shares,omitempty"`
+
+	// CPU hardcap limit (in usecs). Allowed cpu time in a given period.
+	CpuQuota int64 `json:"cpu_quota,omitempty"`
+
+	// CPU period to be used for hardcapping (in usecs). 0 to use system default.
+	CpuPeriod uint64 `json:"cpu_period,omitempty"`
+
+	// CPU to use
+	CpusetCpus string `json:"cpuset_cpus,omitempty"`
+
+	// MEM to use
+	CpusetMems string `json:"cpuset_mems,omitempty"`
+
+	// Process limit; set <= `0' to disable limit.
+	PidsLimit int64 `json:"pids_limit,omitempty"`
+
+	// Memory limit (in bytes), the guest memory plus ContainerMemoryOverhead
+	// for the monitor.
+	Memory int64 `json:"memory,omitempty"`
+}
+
+// MemoryLimit returns the memory limit in bytes of the cgroup of a container
+// whose unikernel has memory MB of guest memory.
+func MemoryLimit(memory int64) int64 {
+	return (memory + ContainerMemoryOverhead) << 20
+}
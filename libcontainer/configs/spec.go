@@ -2,6 +2,7 @@ package configs
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
@@ -47,6 +48,25 @@ func ParseSpec(s *specs.Spec) (*Config, error) {
 		log.Warning("Memory was less than ContainerMemoryMinimum setting to 512mb")
 	}
 
+	var cgroupsPath string
+	if s.Linux != nil && s.Linux.CgroupsPath != "" {
+		// The systemd cgroup driver passes "slice:prefix:name"
+		if strings.Contains(s.Linux.CgroupsPath, ":") {
+			log.Warningf("systemd cgroupsPath %q is not supported, using the default cgroup", s.Linux.CgroupsPath)
+		} else {
+			cgroupsPath = s.Linux.CgroupsPath
+		}
+	}
+
+	resources := &Resources{
+		Memory: MemoryLimit(memory),
+	}
+	if s.Linux != nil && s.Linux.Resources != nil {
+		parseResources(s.Linux.Resources, resources)
+	}
+
+	warnTmpfs(s.Mounts)
+
 	cfg := Config{
 		Args:      s.Process.Args,
 		Rootfs:    s.Root.Path,
@@ -58,7 +78,69 @@ func ParseSpec(s *specs.Spec) (*Config, error) {
 		Hooks:     s.Hooks,
 		Memory:    memory,
 		Mounts:    s.Mounts,
+		Readonly:  s.Root.Readonly,
+
+		CgroupsPath: cgroupsPath,
+		Resources:   resources,
 	}
 
 	return &cfg, nil
 }
+
+// parseResources sets the cgroup limits of r from the spec resources. The
+// memory limit is derived from the guest memory instead.
+func parseResources(sr *specs.LinuxResources, r *Resources) {
+	if sr.CPU != nil {
+		if sr.CPU.Shares != nil {
+			r.CpuShares = *sr.CPU.Shares
+		}
+		if sr.CPU.Quota != nil {
+			r.CpuQuota = *sr.CPU.Quota
+		}
+		if sr.CPU.Period != nil {
+			r.CpuPeriod = *sr.CPU.Period
+		}
+		if sr.CPU.Cpus != "" {
+			r.CpusetCpus = sr.CPU.Cpus
+		}
+		if sr.CPU.Mems != "" {
+			r.CpusetMems = sr.CPU.Mems
+		}
+		if sr.CPU.RealtimeRuntime != nil || sr.CPU.RealtimePeriod != nil {
+			log.Warning("Realtime CPU resources are not supported, ignoring them")
+		}
+	}
+	if sr.Pids != nil {
+		r.PidsLimit = sr.Pids.Limit
+	}
+	if sr.BlockIO != nil {
+		log.Warning("BlockIO resources are not supported, ignoring them")
+	}
+	if len(sr.HugepageLimits) > 0 {
+		log.Warning("Hugepage resources are not supported, ignoring them")
+	}
+	if sr.Network != nil {
+		log.Warning("Network resources are not supported, ignoring them")
+	}
+}
+
+// UpdateResources returns a copy of c with the resources set in sr applied,
+// for updating a running container. The memory limit sets the guest memory.
+func (c Config) UpdateResources(sr *specs.LinuxResources) Config {
+	r := Resources{}
+	if c.Resources != nil {
+		r = *c.Resources
+	}
+	if sr.Memory != nil && sr.Memory.Limit != nil {
+		memory := *sr.Memory.Limit / (1 << 20)
+		if memory < ContainerMemoryMinimum {
+			memory = ContainerMemoryMinimum
+			log.Warning("Memory was less than ContainerMemoryMinimum setting to 512mb")
+		}
+		c.Memory = memory
+		r.Memory = MemoryLimit(memory)
+	}
+	parseResources(sr, &r)
+	c.Resources = &r
+	return c
+}
@@ -0,0 +1,31 @@
+package configs
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// warnTmpfs warns about the tmpfs mounts outside of the kernel filesystems
+// under /dev, /proc and /sys. The blk entries of rumprun only mount block
+// devices, so the unikernel has no in-memory filesystem to give them and
+// they are ignored, as the other mounts it can not honour.
+func warnTmpfs(mounts []specs.Mount) {
+	for _, m := range mounts {
+		if m.Type == "tmpfs" && !isKernelMount(m.Destination) {
+			log.Warningf("tmpfs mount %s is not supported by the unikernel, ignoring it", m.Destination)
+		}
+	}
+}
+
+func isKernelMount(dest string) bool {
+	dest = filepath.Clean(dest)
+	for _, p := range []string{"/dev", "/proc", "/sys"} {
+		if dest == p || strings.HasPrefix(dest, p+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -19,19 +19,23 @@ package libcontainer
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/nabla-containers/runnc/libcontainer/cgroups"
 	"github.com/nabla-containers/runnc/libcontainer/configs"
 	ll "github.com/nabla-containers/runnc/llif"
 	"github.com/opencontainers/runc/libcontainer/system"
-	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 const stdioFdCount = 3
@@ -46,6 +50,10 @@ type State struct {
 
 	// Platform specific fields below here
 	Status Status `json:"status"`
+
+	// CgroupPaths are the directories of the cgroup of the container,
+	// keyed by subsystem
+	CgroupPaths map[string]string `json:"cgroup_paths"`
 }
 
 // Container is a libcontainer container object.
@@ -57,16 +65,36 @@ type Container interface {
 	BaseContainer
 
 	// Methods below here are platform specific
+
+	// Pause freezes the processes of the container, if its state is RUNNING
+	// or CREATED, changing its state to PAUSED. If the state is already
+	// PAUSED, does nothing.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotRunning - Container not running or created,
+	// Systemerror - System error.
+	Pause() error
+
+	// If the Container state is PAUSED, resumes the execution of its
+	// processes and restores the state it had before being paused.
+	//
+	// errors:
+	// ContainerNotExists - Container no longer exists,
+	// ContainerNotPaused - Container is not paused,
+	// Systemerror - System error.
+	Resume() error
 }
 
 type nablaContainer struct {
-	id         string
-	root       string
-	config     *configs.Config
-	m          sync.Mutex
-	state      *State
-	created    time.Time
-	llcHandler ll.RunllcHandler
+	id            string
+	root          string
+	config        *configs.Config
+	m             sync.Mutex
+	state         *State
+	created       time.Time
+	llcHandler    ll.RunllcHandler
+	cgroupManager cgroups.Manager
 }
 
 func (c *nablaContainer) Config() configs.Config {
@@ -95,34 +123,152 @@ func (c *nablaContainer) ID() string {
 	return c.id
 }
 
-// TODO(NABLA)
+// Processes returns the pids of the cgroup of the container: the init process
+// (which becomes nabla-run when it execs) and the processes started by exec.
 func (c *nablaContainer) Processes() ([]int, error) {
-	return nil, errors.New("NablaContainer.Processes not implemented")
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status == Stopped {
+		return []int{}, nil
+	}
+	pids, err := c.cgroupManager.GetPids()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "getting all container pids from cgroups")
+	}
+	sort.Ints(pids)
+	return pids, nil
 }
 
-// TODO(NABLA)
 func (c *nablaContainer) Stats() (*Stats, error) {
-	return nil, errors.New("NablaContainer.Stats not implemented")
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status == Stopped {
+		return nil, newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+
+	// The processes started by exec are counted with nabla-run, see
+	// Processes
+	pids, err := c.cgroupManager.GetPids()
+	if err != nil {
+		return nil, newSystemErrorWithCause(err, "getting all container pids from cgroups")
+	}
+	pid := c.state.InitProcessPid
+	stats := &Stats{}
+	if stats.CPU, stats.Memory, err = getProcessesStats(pids); err != nil {
+		return nil, newSystemErrorWithCause(err, "getting cpu and memory stats")
+	}
+	// IfName is set by the network handlers whose TapName is not the name
+	// of the interface, i.e. a device path
+	ifName := c.state.NetworkState.Options["IfName"]
+	if ifName == "" {
+		ifName = c.state.NetworkState.Options["TapName"]
+	}
+	if ifName != "" {
+		iface, err := getNetworkInterfaceStats(pid, ifName)
+		if err != nil {
+			return nil, newSystemErrorWithCause(err, "getting network stats")
+		}
+		stats.Interfaces = append(stats.Interfaces, iface)
+	}
+	return stats, nil
 }
 
-// TODO(NABLA)
+// Set updates the cgroup limits of the container. The guest memory can only
+// change if the exec handler implements ll.ExecBalloonHandler.
 func (c *nablaContainer) Set(config configs.Config) error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return errors.New("NablaContainer.Set not implemented")
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
+
+	balloonH, canBalloon := c.llcHandler.ExecH.(ll.ExecBalloonHandler)
+	if config.Memory != c.config.Memory && !canBalloon {
+		return newGenericError(fmt.Errorf("cannot change the guest memory from %dMB to %dMB: not supported by the exec handler",
+			c.config.Memory, config.Memory), ConfigInvalid)
+	}
+
+	// The memory limit of the cgroup has to be raised before the guest
+	// grows, and lowered after it shrinks.
+	grow := config.Memory > c.config.Memory
+	if grow {
+		if err := c.cgroupManager.Set(config.Resources); err != nil {
+			return newSystemErrorWithCause(err, "setting cgroup resources")
+		}
+	}
+	if config.Memory != c.config.Memory {
+		execInput := &ll.ExecBalloonInput{
+			ExecGenericInput: ll.ExecGenericInput{
+				ContainerRoot: c.root,
+				Config:        &config,
+				ContainerId:   c.id,
+				FsState:       &c.state.FsState,
+				NetworkState:  &c.state.NetworkState,
+				ExecState:     &c.state.ExecState,
+			},
+			Memory: config.Memory,
+		}
+		execState, err := balloonH.ExecBalloonFunc(execInput)
+		if err != nil {
+			return err
+		}
+		if execState != nil {
+			c.state.ExecState = *execState
+		}
+	}
+	if !grow {
+		if err := c.cgroupManager.Set(config.Resources); err != nil {
+			return newSystemErrorWithCause(err, "setting cgroup resources")
+		}
+	}
+
+	*c.config = config
+	c.state.Config = config
+	return c.saveState(c.state)
 }
 
 func (c *nablaContainer) Start(process *Process) error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return c.start(process)
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Paused {
+		return newGenericError(fmt.Errorf("container paused"), ContainerPaused)
+	}
+	return c.start(process, status == Stopped)
 }
 
-// TODO(NABLA)
 func (c *nablaContainer) Run(process *Process) error {
 	c.m.Lock()
 	defer c.m.Unlock()
-	return errors.New("NablaContainer.Run not implemented")
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Paused {
+		return newGenericError(fmt.Errorf("container paused"), ContainerPaused)
+	}
+	if err := c.start(process, status == Stopped); err != nil {
+		return err
+	}
+	if status == Stopped {
+		return c.exec()
+	}
+	return nil
 }
 
 // TODO(NABLA)
@@ -133,16 +279,74 @@ func (c *nablaContainer) Exec() error {
 }
 
 func (c *nablaContainer) Signal(sig os.Signal, all bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
 	// For nabla container, we only have 1 process
 	s, ok := sig.(syscall.Signal)
 	if !ok {
 		return errors.New("os: unsupported signal type")
 	}
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status == Stopped {
+		return newGenericError(fmt.Errorf("container not running"), ContainerNotRunning)
+	}
 	pid := c.state.InitProcessPid
 	if all {
 		pid = -pid
 	}
-	return syscall.Kill(pid, s)
+	if err := syscall.Kill(pid, s); err != nil {
+		return err
+	}
+	// A frozen process is only killed once it is thawed.
+	if status == Paused && s == unix.SIGKILL {
+		return c.cgroupManager.Freeze(cgroups.Thawed)
+	}
+	return nil
+}
+
+func (c *nablaContainer) Pause() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	switch status {
+	case Running, Created:
+		if err := c.cgroupManager.Freeze(cgroups.Frozen); err != nil {
+			return newSystemErrorWithCause(err, "freezing container")
+		}
+		c.state.Status = Paused
+		return c.saveState(c.state)
+	case Paused:
+		return nil
+	}
+	return newGenericError(fmt.Errorf("container not running or created: %s", status), ContainerNotRunning)
+}
+
+func (c *nablaContainer) Resume() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	status, err := c.currentStatus()
+	if err != nil {
+		return err
+	}
+	if status != Paused {
+		return newGenericError(fmt.Errorf("container not paused"), ContainerNotPaused)
+	}
+	if err := c.cgroupManager.Freeze(cgroups.Thawed); err != nil {
+		return newSystemErrorWithCause(err, "thawing container")
+	}
+	// The exec fifo is removed once the container is started, so a
+	// container paused before start goes back to created.
+	c.state.Status = Running
+	if _, err := os.Stat(filepath.Join(c.root, execFifoFilename)); err == nil {
+		c.state.Status = Created
+	}
+	return c.saveState(c.state)
 }
 
 type nablaProcess struct {
@@ -177,7 +381,9 @@ func NewSockPair(name string) (parent *os.File, child *os.File, err error) {
 	return os.NewFile(uintptr(fds[1]), name+"-p"), os.NewFile(uintptr(fds[0]), name+"-c"), nil
 }
 
-func (c *nablaContainer) start(p *Process) error {
+// start starts the init process of the container if isInit is set, or an
+// additional process against the running container otherwise.
+func (c *nablaContainer) start(p *Process, isInit bool) error {
 	parentPipe, childPipe, err := NewSockPair("init")
 	if err != nil {
 		return newSystemErrorWithCause(err, "creating new init pipe")
@@ -191,13 +397,16 @@ func (c *nablaContainer) start(p *Process) error {
 	// this is to avoid cases where a racing, unprivileged process inside the
 	// container can get access to the statedir file descriptor (which would
 	// allow for container rootfs escape).
-	rootDir, err := os.Open(c.root)
-	if err != nil {
-		return err
+	if isInit {
+		rootDir, err := os.Open(c.root)
+		if err != nil {
+			return err
+		}
+		defer rootDir.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, rootDir)
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("_LIBCONTAINER_STATEDIR=%d", stdioFdCount+len(cmd.ExtraFiles)-1))
 	}
-	cmd.ExtraFiles = append(cmd.ExtraFiles, rootDir)
-	cmd.Env = append(cmd.Env,
-		fmt.Sprintf("_LIBCONTAINER_STATEDIR=%d", stdioFdCount+len(cmd.ExtraFiles)-1))
 
 	// newInitProcess
 	p.ops = &nablaProcess{
@@ -206,7 +415,34 @@ func (c *nablaContainer) start(p *Process) error {
 	}
 
 	defer parentPipe.Close()
+	err = cmd.Start()
+	childPipe.Close()
+	if err != nil {
+		return err
+	}
+
+	if cmd.Process == nil {
+		return errors.New("Cmd.Process is nil after starting")
+	}
+
+	// The process waits for its config, so it is in the cgroup of the
+	// container before it execs anything.
+	if err := c.cgroupManager.Apply(p.ops.pid()); err != nil {
+		p.ops.signal(unix.SIGKILL)
+		p.ops.wait()
+		return newSystemErrorWithCause(err, "applying cgroup configuration for process")
+	}
+	c.state.CgroupPaths = c.cgroupManager.GetPaths()
+	if isInit {
+		if err := c.cgroupManager.Set(c.config.Resources); err != nil {
+			p.ops.signal(unix.SIGKILL)
+			p.ops.wait()
+			return newSystemErrorWithCause(err, "setting cgroup resources")
+		}
+	}
+
 	config := initConfig{
+		Type:         initStandard,
 		Id:           c.id,
 		BundlePath:   c.root,
 		Root:         c.config.Rootfs,
@@ -222,18 +458,23 @@ func (c *nablaContainer) start(p *Process) error {
 		NetworkState: c.state.NetworkState,
 		ExecState:    c.state.ExecState,
 	}
+	if !isInit {
+		// The additional process joins the network namespace of the init
+		// process and runs the process it was given instead.
+		config.Type = initSetns
+		config.Args = p.Args
+		config.Cwd = p.Cwd
+		config.Env = p.Env
+		config.NetnsPath = fmt.Sprintf("/proc/%d/ns/net", c.state.InitProcessPid)
+	}
 
 	enc := json.NewEncoder(parentPipe)
 	if err := enc.Encode(config); err != nil {
 		return err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
-	if cmd.Process == nil {
-		return errors.New("Cmd.Process is nil after starting")
+	if !isInit {
+		return nil
 	}
 
 	c.state.InitProcessPid = p.ops.pid()
@@ -246,7 +487,20 @@ func (c *nablaContainer) start(p *Process) error {
 
 	c.saveState(c.state)
 
-	return nil
+	// Wait for the init process to be done with the Run phase of the fs and
+	// network handlers, and persist the states they returned.
+	var st initState
+	if err := json.NewDecoder(parentPipe).Decode(&st); err != nil {
+		p.ops.signal(unix.SIGKILL)
+		if err == io.EOF {
+			err = errors.New("init process exited during the Run phase of the handlers")
+		}
+		return newSystemErrorWithCause(err, "waiting for init process")
+	}
+	mergeLLState(&c.state.FsState, &st.FsState)
+	mergeLLState(&c.state.NetworkState, &st.NetworkState)
+
+	return c.saveState(c.state)
 }
 
 func (c *nablaContainer) exec() error {
@@ -261,20 +515,45 @@ func (c *nablaContainer) exec() error {
 		return err
 	}
 	if len(data) > 0 {
-		c.state.Status = Running
-		c.saveState(c.state)
+		// The init process may be saving the state of the exec handler
+		// at the same time.
+		state, err := c.updateState(func(s *State) {
+			s.Status = Running
+		})
+		if err != nil {
+			return newSystemErrorWithCause(err, "updating container state")
+		}
+		c.state.Status = state.Status
+		c.state.ExecState = state.ExecState
 		os.Remove(path)
 		return nil
 	}
 	return fmt.Errorf("cannot start an already running container")
 }
 
+// destroy kills the processes left in the cgroup of the container, runs the
+// destroy handlers and removes the cgroup. Everything is torn down even if a
+// step fails, so that a retry does not leak what the later steps release,
+// and the first error is returned.
 func (c *nablaContainer) destroy() error {
+	var firstErr error
+	fail := func(err error) {
+		log.Warning(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// The unikernels of runnc exec are in the cgroup of the container
+	// but not in the process group of its init
+	if err := c.killCgroup(); err != nil {
+		fail(errors.Wrap(err, "killing the processes of the container"))
+	}
 	c.state.InitProcessPid = 0
 	c.state.Status = Stopped
 
 	execInput := &ll.ExecDestroyInput{
-		ll.ExecGenericInput{
+		ExecGenericInput: ll.ExecGenericInput{
 			ContainerRoot: c.root,
 			Config:        c.config,
 			ContainerId:   c.id,
@@ -286,16 +565,34 @@ func (c *nablaContainer) destroy() error {
 
 	execState, err := c.llcHandler.ExecH.ExecDestroyFunc(execInput)
 	if err != nil {
-		return err
-	}
-	if execState != nil {
+		fail(err)
+		execState = &c.state.ExecState
+	} else if execState != nil {
 		c.state.ExecState = *execState
 	} else {
 		c.state.ExecState = ll.LLState{}
 	}
 
+	postStopInput := &ll.FsPostStopInput{
+		FsGenericInput: ll.FsGenericInput{
+			ContainerRoot: c.root,
+			Config:        c.config,
+			ContainerId:   c.id,
+			FsState:       &c.state.FsState,
+			NetworkState:  &c.state.NetworkState,
+			ExecState:     execState,
+		},
+	}
+
+	postStopState, err := c.llcHandler.FsH.FsPostStopFunc(postStopInput)
+	if err != nil {
+		fail(err)
+	} else if postStopState != nil {
+		c.state.FsState = *postStopState
+	}
+
 	fsInput := &ll.FsDestroyInput{
-		ll.FsGenericInput{
+		FsGenericInput: ll.FsGenericInput{
 			ContainerRoot: c.root,
 			Config:        c.config,
 			ContainerId:   c.id,
@@ -307,16 +604,16 @@ func (c *nablaContainer) destroy() error {
 
 	fsState, err := c.llcHandler.FsH.FsDestroyFunc(fsInput)
 	if err != nil {
-		return err
-	}
-	if fsState != nil {
+		fail(err)
+		fsState = &c.state.FsState
+	} else if fsState != nil {
 		c.state.FsState = *fsState
 	} else {
 		c.state.FsState = ll.LLState{}
 	}
 
 	networkInput := &ll.NetworkDestroyInput{
-		ll.NetworkGenericInput{
+		NetworkGenericInput: ll.NetworkGenericInput{
 			ContainerRoot: c.root,
 			Config:        c.config,
 			ContainerId:   c.id,
@@ -328,15 +625,38 @@ func (c *nablaContainer) destroy() error {
 
 	networkState, err := c.llcHandler.NetworkH.NetworkDestroyFunc(networkInput)
 	if err != nil {
-		return err
-	}
-
-	if networkState != nil {
+		fail(err)
+	} else if networkState != nil {
 		c.state.NetworkState = *networkState
 	} else {
 		c.state.NetworkState = ll.LLState{}
 	}
 
+	if err := c.cgroupManager.Destroy(); err != nil {
+		fail(err)
+	} else {
+		c.state.CgroupPaths = nil
+	}
+
+	return firstErr
+}
+
+// killCgroup kills all the processes in the cgroup of the container, so that
+// it can be removed.
+func (c *nablaContainer) killCgroup() error {
+	pids, err := c.cgroupManager.GetPids()
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := unix.Kill(pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
+	// A frozen process is only killed once it is thawed.
+	if len(pids) > 0 && c.state.Status == Paused {
+		return c.cgroupManager.Freeze(cgroups.Thawed)
+	}
 	return nil
 }
 
@@ -393,10 +713,26 @@ func (c *nablaContainer) currentStatus() (Status, error) {
 }
 
 func (c *nablaContainer) saveState(s *State) error {
-	f, err := os.Create(filepath.Join(c.root, stateFilename))
+	dir, err := os.Open(c.root)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	return utils.WriteJSON(f, s)
+	defer dir.Close()
+	unlock, err := lockRoot(int(dir.Fd()))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeStateAt(int(dir.Fd()), s)
+}
+
+// updateState applies fn to the persisted state of the container, which may
+// have been updated by the init process, and returns the result.
+func (c *nablaContainer) updateState(fn func(*State)) (*State, error) {
+	dir, err := os.Open(c.root)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return updateStateAt(int(dir.Fd()), fn)
 }
@@ -22,8 +22,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall"
 
+	"github.com/nabla-containers/runnc/libcontainer/cgroups"
 	"github.com/nabla-containers/runnc/libcontainer/configs"
 	ll "github.com/nabla-containers/runnc/llif"
 
@@ -34,6 +36,10 @@ const (
 	stateFilename    = "state.json"
 	execFifoFilename = "exec.fifo"
 	pauseNablaName   = "pause.nabla"
+
+	// defaultCgroupParent is the cgroup under which a cgroup is created for
+	// each container
+	defaultCgroupParent = "/runnc"
 )
 
 var (
@@ -91,7 +97,19 @@ func nablaTapName(id string) string {
 	return ("tap" + id)[:syscall.IFNAMSIZ-1]
 }
 
-func (l *NablaFactory) Create(id string, config *configs.Config) (Container, error) {
+// cgroupPath returns the cgroup of a given container ID and config
+func cgroupPath(id string, config *configs.Config) string {
+	switch {
+	case config.CgroupsPath == "":
+		return filepath.Join(defaultCgroupParent, id)
+	case filepath.IsAbs(config.CgroupsPath):
+		return filepath.Clean(config.CgroupsPath)
+	default:
+		return filepath.Join(defaultCgroupParent, config.CgroupsPath)
+	}
+}
+
+func (l *NablaFactory) Create(id string, config *configs.Config) (_ Container, err error) {
 	if l.Root == "" {
 		return nil, fmt.Errorf("invalid root")
 	}
@@ -120,6 +138,18 @@ func (l *NablaFactory) Create(id string, config *configs.Config) (Container, err
 		return nil, err
 	}
 
+	// From here on, a failure destroys what the handlers already created,
+	// in reverse order, and removes the container root.
+	var cleanups []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		if cerr := rollbackCreate(containerRoot, cleanups); cerr != nil {
+			err = fmt.Errorf("%v (cleanup failed: %v)", err, cerr)
+		}
+	}()
+
 	if err := os.Chown(containerRoot, uid, gid); err != nil {
 		return nil, err
 	}
@@ -134,38 +164,24 @@ func (l *NablaFactory) Create(id string, config *configs.Config) (Container, err
 		return nil, err
 	}
 
-	var fsState *ll.LLState
 	// If it is a pause container for kubernetes, set config so that init
 	// will just pause instead of executing a nabla
-	if isPauseContainer(config) {
+	pause := isPauseContainer(config)
+	if pause {
 		config, err = applyPauseHack(config, containerRoot)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		fsInput := &ll.FsCreateInput{
-			ll.FsGenericInput{
-				ContainerRoot: containerRoot,
-				Config:        config,
-				ContainerId:   id,
-				FsState:       &ll.LLState{},
-				NetworkState:  &ll.LLState{},
-				ExecState:     &ll.LLState{},
-			},
-		}
-
-		fsState, err = l.LLCHandler.FsH.FsCreateFunc(fsInput)
-		if err != nil {
-			return nil, fmt.Errorf("Error running FsCreateFunc: %v", err)
-		}
 	}
 
+	// The network comes first, so that the fs handler can write its
+	// resolver settings into the rootfs
 	networkInput := &ll.NetworkCreateInput{
-		ll.NetworkGenericInput{
+		NetworkGenericInput: ll.NetworkGenericInput{
 			ContainerRoot: containerRoot,
 			Config:        config,
 			ContainerId:   id,
-			FsState:       fsState,
+			FsState:       &ll.LLState{},
 			NetworkState:  &ll.LLState{},
 			ExecState:     &ll.LLState{},
 		},
@@ -173,12 +189,68 @@ func (l *NablaFactory) Create(id string, config *configs.Config) (Container, err
 
 	networkState, err := l.LLCHandler.NetworkH.NetworkCreateFunc(networkInput)
 	if err != nil {
-		// TODO(runllc): Handle error case for Fs Handler - run FsDestroyFunc
 		return nil, fmt.Errorf("Error running NetworkCreateFunc: %v", err)
 	}
+	if networkState == nil {
+		networkState = &ll.LLState{}
+	}
+	fsState := &ll.LLState{}
+	cleanups = append(cleanups, func() error {
+		_, err := l.LLCHandler.NetworkH.NetworkDestroyFunc(&ll.NetworkDestroyInput{
+			NetworkGenericInput: ll.NetworkGenericInput{
+				ContainerRoot: containerRoot,
+				Config:        config,
+				ContainerId:   id,
+				FsState:       fsState,
+				NetworkState:  networkState,
+				ExecState:     &ll.LLState{},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error running NetworkDestroyFunc: %v", err)
+		}
+		return nil
+	})
+
+	if !pause {
+		fsInput := &ll.FsCreateInput{
+			FsGenericInput: ll.FsGenericInput{
+				ContainerRoot: containerRoot,
+				Config:        config,
+				ContainerId:   id,
+				FsState:       &ll.LLState{},
+				NetworkState:  networkState,
+				ExecState:     &ll.LLState{},
+			},
+		}
+
+		state, err := l.LLCHandler.FsH.FsCreateFunc(fsInput)
+		if err != nil {
+			return nil, fmt.Errorf("Error running FsCreateFunc: %v", err)
+		}
+		if state != nil {
+			fsState = state
+		}
+		cleanups = append(cleanups, func() error {
+			_, err := l.LLCHandler.FsH.FsDestroyFunc(&ll.FsDestroyInput{
+				FsGenericInput: ll.FsGenericInput{
+					ContainerRoot: containerRoot,
+					Config:        config,
+					ContainerId:   id,
+					FsState:       fsState,
+					NetworkState:  networkState,
+					ExecState:     &ll.LLState{},
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("Error running FsDestroyFunc: %v", err)
+			}
+			return nil
+		})
+	}
 
 	execInput := &ll.ExecCreateInput{
-		ll.ExecGenericInput{
+		ExecGenericInput: ll.ExecGenericInput{
 			ContainerRoot: containerRoot,
 			Config:        config,
 			ContainerId:   id,
@@ -190,26 +262,18 @@ func (l *NablaFactory) Create(id string, config *configs.Config) (Container, err
 
 	execState, err := l.LLCHandler.ExecH.ExecCreateFunc(execInput)
 	if err != nil {
-		// TODO(runllc): Handle error case for Fs Handler - run FsDestroyFunc
-		// TODO(runllc): Handle error case for Net Handler - run NetDestroyFunc
 		return nil, fmt.Errorf("Error running ExecCreateFunc: %v", err)
 	}
-
-	if networkState == nil {
-		networkState = &ll.LLState{}
-	}
-	if fsState == nil {
-		fsState = &ll.LLState{}
-	}
 	if execState == nil {
 		execState = &ll.LLState{}
 	}
 
 	c := &nablaContainer{
-		id:         id,
-		root:       containerRoot,
-		config:     config,
-		llcHandler: l.LLCHandler,
+		id:            id,
+		root:          containerRoot,
+		config:        config,
+		llcHandler:    l.LLCHandler,
+		cgroupManager: cgroups.NewManager(cgroupPath(id, config), nil),
 		state: &State{
 			BaseState: BaseState{
 				ID:     id,
@@ -224,6 +288,24 @@ func (l *NablaFactory) Create(id string, config *configs.Config) (Container, err
 	return c, nil
 }
 
+// rollbackCreate runs the cleanups of a failed Create in reverse order and
+// removes the container root. It returns the errors of all of them.
+func rollbackCreate(containerRoot string, cleanups []func() error) error {
+	var errs []string
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		if err := cleanups[i](); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := os.RemoveAll(containerRoot); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 func (l *NablaFactory) Load(id string) (Container, error) {
 	if l.Root == "" {
 		return nil, newGenericError(fmt.Errorf("invalid root"), ConfigInvalid)
@@ -235,11 +317,12 @@ func (l *NablaFactory) Load(id string) (Container, error) {
 	}
 
 	c := &nablaContainer{
-		id:         id,
-		root:       containerRoot,
-		config:     &state.Config,
-		state:      state,
-		llcHandler: l.LLCHandler,
+		id:            id,
+		root:          containerRoot,
+		config:        &state.Config,
+		state:         state,
+		llcHandler:    l.LLCHandler,
+		cgroupManager: cgroups.NewManager(cgroupPath(id, &state.Config), state.CgroupPaths),
 	}
 
 	return c, nil
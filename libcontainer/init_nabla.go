@@ -25,9 +25,18 @@ import (
 	ll "github.com/nabla-containers/runnc/llif"
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+type initType string
+
+const (
+	initSetns    initType = "setns"
+	initStandard initType = "standard"
 )
 
 type initConfig struct {
+	Type       initType        `json:"type"`
 	Id         string          `json:"id"`
 	BundlePath string          `json:"bundlepath"`
 	Root       string          `json:"root"`
@@ -43,7 +52,14 @@ type initConfig struct {
 
 	FsState      ll.LLState `json:"fsstate"`
 	NetworkState ll.LLState `json:"netstate"`
-	ExecState    ll.LLState `json:execstate"`
+	ExecState    ll.LLState `json:"execstate"`
+}
+
+// initState is sent back to the parent by the init process, with the states
+// returned by the Run phase of the fs and network handlers.
+type initState struct {
+	FsState      ll.LLState `json:"fsstate"`
+	NetworkState ll.LLState `json:"netstate"`
 }
 
 func initNabla(llcHandler ll.RunllcHandler) error {
@@ -67,10 +83,17 @@ func initNabla(llcHandler ll.RunllcHandler) error {
 		return err
 	}
 
+	if config.Type == initSetns {
+		return initSetnsNabla(llcHandler, config)
+	}
+
 	// Only init processes have STATEDIR.
 	if rootfd, err = strconv.Atoi(envStateDir); err != nil {
 		return fmt.Errorf("unable to convert _LIBCONTAINER_STATEDIR=%s to int: %s", envStateDir, err)
 	}
+	// rootfd stays open for the exec handler to save its state, but it must
+	// not leak into the unikernel monitor.
+	unix.CloseOnExec(rootfd)
 
 	// clear the current process's environment to clean any libcontainer
 	// specific env vars.
@@ -78,7 +101,7 @@ func initNabla(llcHandler ll.RunllcHandler) error {
 
 	// LLC Fs Handle
 	fsInput := &ll.FsRunInput{
-		ll.FsGenericInput{
+		FsGenericInput: ll.FsGenericInput{
 			ContainerRoot: config.Root,
 			Config:        config.Config,
 			ContainerId:   config.Id,
@@ -88,11 +111,13 @@ func initNabla(llcHandler ll.RunllcHandler) error {
 		},
 	}
 
-	// TODO(runllc): Propagate and store LLstates
 	fsState, err := llcHandler.FsH.FsRunFunc(fsInput)
 	if err != nil {
 		return fmt.Errorf("Error running llc Fs handler: %v", err)
 	}
+	// The next handlers get the options of the Create phase along with
+	// the ones of the Run phase, as the parent persists them
+	mergedFsState := mergedLLState(&config.FsState, fsState)
 
 	// Go into network namespace for temporary hack for CNI plugin using veth pairs
 	// K8s case
@@ -126,23 +151,34 @@ func initNabla(llcHandler ll.RunllcHandler) error {
 	}
 
 	networkInput := &ll.NetworkRunInput{
-		ll.NetworkGenericInput{
+		NetworkGenericInput: ll.NetworkGenericInput{
 			ContainerRoot: config.Root,
 			Config:        config.Config,
 			ContainerId:   config.Id,
-			FsState:       fsState,
+			FsState:       mergedFsState,
 			NetworkState:  &config.NetworkState,
 			ExecState:     &config.ExecState,
 		},
 	}
 
-	// TODO(runllc): Propagate and store LLstates
 	networkState, err := llcHandler.NetworkH.NetworkRunFunc(networkInput)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running llc Network handler: %v", err)
 		return fmt.Errorf("Error running llc Network handler: %v", err)
 	}
 
+	// Send the Run phase states to the parent, which persists them.
+	st := initState{}
+	if fsState != nil {
+		st.FsState = *fsState
+	}
+	if networkState != nil {
+		st.NetworkState = *networkState
+	}
+	if err := json.NewEncoder(pipe).Encode(st); err != nil {
+		return newSystemErrorWithCause(err, "sending states to parent")
+	}
+
 	// wait for the fifo to be opened on the other side before
 	// exec'ing the users process.
 	fd, err := syscall.Openat(rootfd, execFifoFilename, os.O_WRONLY|syscall.O_CLOEXEC, 0)
@@ -153,7 +189,6 @@ func initNabla(llcHandler ll.RunllcHandler) error {
 		return newSystemErrorWithCause(err, "write 0 exec fifo")
 	}
 	syscall.Close(fd)
-	syscall.Close(rootfd)
 
 	// Check if it is a pause container, if it is, just pause
 	if len(config.Args) == 1 && config.Args[0] == pauseNablaName {
@@ -162,16 +197,56 @@ func initNabla(llcHandler ll.RunllcHandler) error {
 
 	// LLC Exec Handle
 	execInput := &ll.ExecRunInput{
-		ll.ExecGenericInput{
+		ExecGenericInput: ll.ExecGenericInput{
 			ContainerRoot: config.Root,
 			Config:        config.Config,
 			ContainerId:   config.Id,
-			FsState:       fsState,
-			NetworkState:  networkState,
+			FsState:       mergedFsState,
+			NetworkState:  mergedLLState(&config.NetworkState, networkState),
 			ExecState:     &config.ExecState,
 		},
+		SaveState: func(s *ll.LLState) error {
+			_, err := updateStateAt(rootfd, func(state *State) {
+				mergeLLState(&state.ExecState, s)
+			})
+			return err
+		},
 	}
 
 	// Should not return if successful
 	return llcHandler.ExecH.ExecRunFunc(execInput)
 }
+
+// initSetnsNabla runs an additional process (i.e. `exec`) against a running
+// container.
+func initSetnsNabla(llcHandler ll.RunllcHandler, config *initConfig) error {
+	// clear the current process's environment to clean any libcontainer
+	// specific env vars.
+	os.Clearenv()
+
+	nsh, err := netns.GetFromPath(config.NetnsPath)
+	if err != nil {
+		return newSystemErrorWithCause(err, "unable to get netns handle")
+	}
+
+	if err := netns.Set(nsh); err != nil {
+		return newSystemErrorWithCause(err, "unable to get set netns")
+	}
+
+	execInput := &ll.ExecExtraProcessInput{
+		ExecGenericInput: ll.ExecGenericInput{
+			ContainerRoot: config.Root,
+			Config:        config.Config,
+			ContainerId:   config.Id,
+			FsState:       &config.FsState,
+			NetworkState:  &config.NetworkState,
+			ExecState:     &config.ExecState,
+		},
+		Args: config.Args,
+		Env:  config.Env,
+		Cwd:  config.Cwd,
+	}
+
+	// Should not return if successful
+	return llcHandler.ExecH.ExecExtraProcessFunc(execInput)
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procStat holds the fields of /proc/<pid>/stat used by the runtime.
+type procStat struct {
+	Pid int
+	// Utime and Stime are the user and system time in clock ticks
+	Utime uint64
+	Stime uint64
+}
+
+// readProcStat parses /proc/<pid>/stat, see proc(5).
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name is in parentheses and may contain spaces or
+	// parentheses itself, so the fields start after the last ')'.
+	s := string(data)
+	i := strings.LastIndex(s, ")")
+	if i < 0 {
+		return nil, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+	// fields[0] is the state, which is field 3 in proc(5)
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 13 {
+		return nil, fmt.Errorf("unexpected format of /proc/%d/stat", pid)
+	}
+
+	st := &procStat{Pid: pid}
+	if st.Utime, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+		return nil, err
+	}
+	if st.Stime, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
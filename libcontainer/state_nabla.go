@@ -0,0 +1,120 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package libcontainer
+
+import (
+	"encoding/json"
+	"os"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"golang.org/x/sys/unix"
+)
+
+// The state of a container is written both by runnc and by the init process
+// of the container, which only has a file descriptor of the container root.
+// Updates are serialized with a lock on the container root and state.json
+// is replaced atomically, so readers never see a partial state.
+
+// lockRoot takes an exclusive lock on the container root dirfd.
+func lockRoot(dirfd int) (unlock func(), err error) {
+	if err := unix.Flock(dirfd, unix.LOCK_EX); err != nil {
+		return nil, err
+	}
+	return func() { unix.Flock(dirfd, unix.LOCK_UN) }, nil
+}
+
+// readStateAt reads state.json from the container root dirfd.
+func readStateAt(dirfd int) (*State, error) {
+	fd, err := unix.Openat(dirfd, stateFilename, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), stateFilename)
+	defer f.Close()
+	var state *State
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// writeStateAt replaces state.json in the container root dirfd.
+func writeStateAt(dirfd int, s *State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmpName := stateFilename + ".tmp"
+	fd, err := unix.Openat(dirfd, tmpName, unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC|unix.O_CLOEXEC, 0644)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), tmpName)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return unix.Renameat(dirfd, tmpName, dirfd, stateFilename)
+}
+
+// updateStateAt applies fn to the state of the container root dirfd and
+// returns the updated state.
+func updateStateAt(dirfd int, fn func(*State)) (*State, error) {
+	unlock, err := lockRoot(dirfd)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	state, err := readStateAt(dirfd)
+	if err != nil {
+		return nil, err
+	}
+	fn(state)
+	if err := writeStateAt(dirfd, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// mergedLLState returns a state with the options of base and the ones of src,
+// which replace them, like mergeLLState without changing base. The in-memory
+// objects are the ones of src, which is of the current phase.
+func mergedLLState(base *ll.LLState, src *ll.LLState) *ll.LLState {
+	ret := &ll.LLState{Options: map[string]string{}}
+	mergeLLState(ret, base)
+	mergeLLState(ret, src)
+	if src != nil {
+		ret.InMemoryObjects = src.InMemoryObjects
+	}
+	return ret
+}
+
+// mergeLLState adds the options of src to dst, replacing the existing ones.
+func mergeLLState(dst *ll.LLState, src *ll.LLState) {
+	if src == nil || len(src.Options) == 0 {
+		return
+	}
+	if dst.Options == nil {
+		dst.Options = map[string]string{}
+	}
+	for k, v := range src.Options {
+		dst.Options[k] = v
+	}
+}
@@ -16,4 +16,38 @@ package libcontainer
 
 // Stats contains statistics about the container
 type Stats struct {
+	// CPU is the CPU time used by the nabla-run monitor of the unikernel
+	CPU CPUStats
+	// Memory is the memory used by the nabla-run monitor of the unikernel
+	Memory MemoryStats
+	// Interfaces are the statistics of the tap devices of the unikernel
+	Interfaces []*NetworkInterface
+}
+
+// CPUStats is the CPU time in nanoseconds
+type CPUStats struct {
+	TotalUsage        uint64
+	UsageInUsermode   uint64
+	UsageInKernelmode uint64
+}
+
+// MemoryStats is the memory usage in bytes
+type MemoryStats struct {
+	// RSS is the resident set size, which includes the guest memory
+	// touched by the unikernel
+	RSS uint64
+}
+
+type NetworkInterface struct {
+	// Name is the name of the network interface.
+	Name string
+
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
 }
@@ -0,0 +1,128 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package libcontainer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+const nanoSecondsPerSecond = 1000000000
+
+// getCPUStats returns the CPU time used by the process pid.
+func getCPUStats(pid int) (CPUStats, error) {
+	st, err := readProcStat(pid)
+	if err != nil {
+		return CPUStats{}, err
+	}
+	ticks := uint64(system.GetClockTicks())
+	user := st.Utime * nanoSecondsPerSecond / ticks
+	kernel := st.Stime * nanoSecondsPerSecond / ticks
+	return CPUStats{
+		TotalUsage:        user + kernel,
+		UsageInUsermode:   user,
+		UsageInKernelmode: kernel,
+	}, nil
+}
+
+// getMemoryStats returns the memory used by the process pid, the resident
+// set size is the second field of /proc/<pid>/statm in pages.
+func getMemoryStats(pid int) (MemoryStats, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return MemoryStats{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return MemoryStats{}, fmt.Errorf("unexpected format of /proc/%d/statm", pid)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return MemoryStats{}, err
+	}
+	return MemoryStats{RSS: pages * uint64(os.Getpagesize())}, nil
+}
+
+// getProcessesStats returns the CPU time and the memory used by the processes
+// pids together. The processes that exit meanwhile are skipped.
+func getProcessesStats(pids []int) (CPUStats, MemoryStats, error) {
+	var cpu CPUStats
+	var memory MemoryStats
+	for _, pid := range pids {
+		c, err := getCPUStats(pid)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return cpu, memory, err
+		}
+		m, err := getMemoryStats(pid)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return cpu, memory, err
+		}
+		cpu.TotalUsage += c.TotalUsage
+		cpu.UsageInUsermode += c.UsageInUsermode
+		cpu.UsageInKernelmode += c.UsageInKernelmode
+		memory.RSS += m.RSS
+	}
+	return cpu, memory, nil
+}
+
+// getNetworkInterfaceStats returns the counters of the interface name in the
+// network namespace of the process pid.
+func getNetworkInterfaceStats(pid int, name string) (*NetworkInterface, error) {
+	ns, err := netns.GetFromPid(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Delete()
+
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	s := link.Attrs().Statistics
+	if s == nil {
+		return nil, fmt.Errorf("no statistics for interface %s", name)
+	}
+	return &NetworkInterface{
+		Name:      name,
+		RxBytes:   s.RxBytes,
+		RxPackets: s.RxPackets,
+		RxErrors:  s.RxErrors,
+		RxDropped: s.RxDropped,
+		TxBytes:   s.TxBytes,
+		TxPackets: s.TxPackets,
+		TxErrors:  s.TxErrors,
+		TxDropped: s.TxDropped,
+	}, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nabla-containers/runnc/libcontainer"
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// event struct for encoding the event data to json.
+type event struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// stats is the runc event format of the statistics of a container, only the
+// parts that apply to a unikernel are filled in.
+type stats struct {
+	CPU               cpu                 `json:"cpu"`
+	Memory            memory              `json:"memory"`
+	NetworkInterfaces []*networkInterface `json:"network_interfaces,omitempty"`
+}
+
+type cpu struct {
+	Usage cpuUsage `json:"usage,omitempty"`
+}
+
+type cpuUsage struct {
+	// Units: nanoseconds.
+	Total  uint64 `json:"total,omitempty"`
+	Kernel uint64 `json:"kernel"`
+	User   uint64 `json:"user"`
+}
+
+type memory struct {
+	Usage memoryEntry       `json:"usage,omitempty"`
+	Raw   map[string]uint64 `json:"raw,omitempty"`
+}
+
+type memoryEntry struct {
+	Limit   uint64 `json:"limit"`
+	Usage   uint64 `json:"usage,omitempty"`
+	Max     uint64 `json:"max,omitempty"`
+	Failcnt uint64 `json:"failcnt"`
+}
+
+type networkInterface struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+func newEventsCmd(llcHandler ll.RunllcHandler, sf stringSubFunc) cli.Command {
+	return cli.Command{
+		Name:  "events",
+		Usage: "display container events such as cpu, memory, and network usage statistics",
+		ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+		Description: `The events command displays information about the container. By default the
+information is displayed once every 5 seconds.`,
+		Flags: []cli.Flag{
+			cli.DurationFlag{Name: "interval", Value: 5 * time.Second, Usage: "set the stats collection interval"},
+			cli.BoolFlag{Name: "stats", Usage: "display the container's stats then exit"},
+		},
+		Action: func(context *cli.Context) error {
+			container, err := getContainer(context, llcHandler)
+			if err != nil {
+				return err
+			}
+			duration := context.Duration("interval")
+			if duration <= 0 {
+				return fmt.Errorf("duration interval must be greater than 0")
+			}
+			status, err := container.Status()
+			if err != nil {
+				return err
+			}
+			if status == libcontainer.Stopped {
+				return fmt.Errorf("container with id %s is not running", container.ID())
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			if context.Bool("stats") {
+				s, err := container.Stats()
+				if err != nil {
+					return err
+				}
+				return enc.Encode(&event{Type: "stats", ID: container.ID(), Data: convertLibcontainerStats(s)})
+			}
+
+			ticker := time.NewTicker(duration)
+			defer ticker.Stop()
+			for range ticker.C {
+				s, err := container.Stats()
+				if err != nil {
+					// Stop streaming once the unikernel exited
+					if status, serr := container.Status(); serr == nil && status == libcontainer.Stopped {
+						return nil
+					}
+					logrus.Error(err)
+					continue
+				}
+				if err := enc.Encode(&event{Type: "stats", ID: container.ID(), Data: convertLibcontainerStats(s)}); err != nil {
+					logrus.Error(err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func convertLibcontainerStats(ls *libcontainer.Stats) *stats {
+	if ls == nil {
+		return nil
+	}
+	var s stats
+	s.CPU.Usage.Total = ls.CPU.TotalUsage
+	s.CPU.Usage.User = ls.CPU.UsageInUsermode
+	s.CPU.Usage.Kernel = ls.CPU.UsageInKernelmode
+
+	s.Memory.Usage.Usage = ls.Memory.RSS
+	s.Memory.Raw = map[string]uint64{"rss": ls.Memory.RSS}
+
+	for _, iface := range ls.Interfaces {
+		s.NetworkInterfaces = append(s.NetworkInterfaces, &networkInterface{
+			Name:      iface.Name,
+			RxBytes:   iface.RxBytes,
+			RxPackets: iface.RxPackets,
+			RxErrors:  iface.RxErrors,
+			RxDropped: iface.RxDropped,
+			TxBytes:   iface.TxBytes,
+			TxPackets: iface.TxPackets,
+			TxErrors:  iface.TxErrors,
+			TxDropped: iface.TxDropped,
+		})
+	}
+	return &s
+}
@@ -15,16 +15,24 @@
 package llcli
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/nabla-containers/runnc/libcontainer"
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/urfave/cli"
 )
 
-func newExecCmd() cli.Command {
+func newExecCmd(llcHandler ll.RunllcHandler, sf stringSubFunc) cli.Command {
 	return cli.Command{
 		Name:  "exec",
 		Usage: "execute new process inside the container",
-		ArgsUsage: `<container-id> <command> [command options]  || -p process.json <container-id>
+		ArgsUsage: sf(`<container-id> <command> [command options]  || -p process.json <container-id>
 
 Where "<container-id>" is the name for the instance of the container and
 "<command>" is the command to be executed in the container.
@@ -34,7 +42,10 @@ EXAMPLE:
 For example, if the container is configured to run the linux ps command the
 following will output a list of processes running in the container:
 
-       # {{name}} exec <container-id> ps`,
+       # {{name}} exec <container-id> ps.nabla
+
+The process is run as an additional unikernel that boots from the root
+filesystem of the container, without networking.`),
 		Flags: []cli.Flag{
 			cli.StringFlag{
 				Name:  "console-socket",
@@ -97,9 +108,90 @@ following will output a list of processes running in the container:
 			},
 		},
 		Action: func(context *cli.Context) error {
-			// TODO: implement
-			return fmt.Errorf("OCI Exec Not Implemented")
+			status, err := execProcess(context, llcHandler)
+			if err == nil {
+				os.Exit(status)
+			}
+			return fmt.Errorf("exec failed: %v", err)
 		},
 		SkipArgReorder: true,
 	}
 }
+
+func execProcess(context *cli.Context, llcHandler ll.RunllcHandler) (int, error) {
+	container, err := getContainer(context, llcHandler)
+	if err != nil {
+		return -1, err
+	}
+	status, err := container.Status()
+	if err != nil {
+		return -1, err
+	}
+	if status == libcontainer.Stopped {
+		return -1, fmt.Errorf("cannot exec a container that has stopped")
+	}
+	path := context.String("process")
+	if path == "" && len(context.Args()) == 1 {
+		return -1, fmt.Errorf("process args cannot be empty")
+	}
+	p, err := getProcess(context, container.Config())
+	if err != nil {
+		return -1, err
+	}
+
+	r := &runner{
+		enableSubreaper: false,
+		shouldDestroy:   false,
+		container:       container,
+		console:         context.String("console"),
+		detach:          context.Bool("detach"),
+		pidFile:         context.String("pid-file"),
+	}
+	return r.run(p)
+}
+
+// getProcess returns the process to execute, either read from the
+// process.json passed with --process or built from the command line on top
+// of the process configuration of the container.
+func getProcess(context *cli.Context, config configs.Config) (*specs.Process, error) {
+	if path := context.String("process"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var p specs.Process
+		if err := json.NewDecoder(f).Decode(&p); err != nil {
+			return nil, err
+		}
+		return &p, validateProcessSpec(&p)
+	}
+
+	p := &specs.Process{
+		Args:     context.Args()[1:],
+		Env:      append(append([]string{}, config.Env...), context.StringSlice("env")...),
+		Cwd:      config.Cwd,
+		Terminal: context.Bool("tty"),
+	}
+	// override the cwd, if passed
+	if context.String("cwd") != "" {
+		p.Cwd = context.String("cwd")
+	}
+	// override the user, if passed
+	if context.String("user") != "" {
+		u := strings.SplitN(context.String("user"), ":", 2)
+		if len(u) > 1 {
+			gid, err := strconv.Atoi(u[1])
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s as int for gid failed: %v", u[1], err)
+			}
+			p.User.GID = uint32(gid)
+		}
+		uid, err := strconv.Atoi(u[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s as int for uid failed: %v", u[0], err)
+		}
+		p.User.UID = uint32(uid)
+	}
+	return p, validateProcessSpec(p)
+}
@@ -0,0 +1,187 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nabla-containers/runnc/libcontainer"
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/urfave/cli"
+)
+
+const formatOptions = `table or json`
+
+// containerListState represents the platform agnostic pieces relating to a
+// running container's status and state, plus the network details of the
+// unikernel.
+type containerListState struct {
+	// Version is the OCI version for the container
+	Version string `json:"ociVersion"`
+	// ID is the container ID
+	ID string `json:"id"`
+	// InitProcessPid is the init process id in the parent namespace
+	InitProcessPid int `json:"pid"`
+	// Status is the current status of the container, running, paused, ...
+	Status string `json:"status"`
+	// Bundle is the path on the filesystem to the bundle
+	Bundle string `json:"bundle"`
+	// Rootfs is a path to a directory containing the container's root filesystem.
+	Rootfs string `json:"rootfs"`
+	// Created is the unix timestamp for the creation time of the container in UTC
+	Created time.Time `json:"created"`
+	// TapName is the tap device the unikernel is attached to
+	TapName string `json:"tap,omitempty"`
+	// IPAddress is the IP address assigned to the unikernel, set by the
+	// network handler in the Run phase and persisted by the init process
+	IPAddress string `json:"ip,omitempty"`
+}
+
+func newListCmd(llcHandler ll.RunllcHandler, sf stringSubFunc) cli.Command {
+	return cli.Command{
+		Name:  "list",
+		Usage: sf("lists containers started by {{name}} with the given root"),
+		ArgsUsage: sf(`
+
+Where the given root is specified via the global option "--root"
+(default: "/run/{{name}}").
+
+EXAMPLE 1:
+To list containers created via the default "--root":
+       # {{name}} list
+
+EXAMPLE 2:
+To list containers created using a non-default value for "--root":
+       # {{name}} --root value list`),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format, f",
+				Value: "table",
+				Usage: `select one of: ` + formatOptions,
+			},
+			cli.BoolFlag{
+				Name:  "quiet, q",
+				Usage: "display only container IDs",
+			},
+		},
+		Action: func(context *cli.Context) error {
+			s, err := getContainers(context, llcHandler)
+			if err != nil {
+				return err
+			}
+
+			if context.Bool("quiet") {
+				for _, item := range s {
+					fmt.Println(item.ID)
+				}
+				return nil
+			}
+
+			switch context.String("format") {
+			case "table":
+				w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+				fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tCREATED\tTAP\tIP\n")
+				for _, item := range s {
+					fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+						item.ID,
+						item.InitProcessPid,
+						item.Status,
+						item.Bundle,
+						item.Created.Format(time.RFC3339Nano),
+						item.TapName,
+						item.IPAddress)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			case "json":
+				if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("invalid format option")
+			}
+			return nil
+		},
+	}
+}
+
+// getContainers loads every container found under the root of the factory.
+func getContainers(context *cli.Context, llcHandler ll.RunllcHandler) ([]containerListState, error) {
+	factory, err := loadFactory(context, llcHandler)
+	if err != nil {
+		return nil, err
+	}
+	root, err := rootDir(context)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	s := []containerListState{}
+	for _, item := range list {
+		// Hidden directories hold data of the runtime, like the ISO
+		// cache, and are not containers.
+		if !item.IsDir() || strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+		container, err := factory.Load(item.Name())
+		if err != nil {
+			// Directories without a state (i.e. an aborted create) are
+			// not containers yet.
+			if lerr, ok := err.(libcontainer.Error); ok && lerr.Code() == libcontainer.ContainerNotExists {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "load container %s: %v\n", item.Name(), err)
+			continue
+		}
+		containerStatus, err := container.Status()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "status for %s: %v\n", item.Name(), err)
+			continue
+		}
+		state, err := container.State()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "state for %s: %v\n", item.Name(), err)
+			continue
+		}
+		pid := state.BaseState.InitProcessPid
+		if containerStatus == libcontainer.Stopped {
+			pid = 0
+		}
+		s = append(s, containerListState{
+			Version:        state.BaseState.Config.Version,
+			ID:             state.BaseState.ID,
+			InitProcessPid: pid,
+			Status:         containerStatus.String(),
+			Bundle:         utils.SearchLabels(state.Config.Labels, "bundle"),
+			Rootfs:         state.BaseState.Config.Rootfs,
+			Created:        state.BaseState.Created,
+			TapName:        state.NetworkState.Options["TapName"],
+			IPAddress:      state.NetworkState.Options["IPAddress"],
+		})
+	}
+	return s, nil
+}
@@ -57,8 +57,9 @@ value for "bundle" is the current directory.`
 
 // Runllc takes in a set of low level handlers (llcHandler), the name of the
 // runtime (i.e. "runnc"), and the container root to use and runs the CLI
-// of an OCI container runtime.
-func Runllc(runtimeName string, runtimeRoot string, llcHandler ll.RunllcHandler) {
+// of an OCI container runtime. flags are added to the global flags of the
+// runtime, i.e. for the options of the handlers.
+func Runllc(runtimeName string, runtimeRoot string, llcHandler ll.RunllcHandler, flags ...cli.Flag) {
 	app := cli.NewApp()
 	app.Name = runtimeName
 
@@ -101,6 +102,7 @@ func Runllc(runtimeName string, runtimeRoot string, llcHandler ll.RunllcHandler)
 			Usage: "root directory for storage of container state (this should be located in tmpfs)",
 		},
 	}
+	app.Flags = append(app.Flags, flags...)
 	app.Commands = []cli.Command{
 		// Implement essentials first (for basic docker run to work)
 		newCreateCmd(llcHandler, strFn),
@@ -109,17 +111,17 @@ func Runllc(runtimeName string, runtimeRoot string, llcHandler ll.RunllcHandler)
 		newStartCmd(llcHandler, strFn),
 		newKillCmd(llcHandler, strFn),
 		newInitCmd(llcHandler, strFn),
+		newRunCmd(llcHandler, strFn),
+		newExecCmd(llcHandler, strFn),
+		newListCmd(llcHandler, strFn),
+		newPsCmd(llcHandler, strFn),
+		newEventsCmd(llcHandler, strFn),
+		newPauseCmd(llcHandler, strFn),
+		newResumeCmd(llcHandler, strFn),
+		newUpdateCmd(llcHandler, strFn),
 		//		checkpointCommand,
-		//		eventsCommand,
-		//		execCommand,
-		//		listCommand,
-		//		pauseCommand,
-		//		psCommand,
 		//		restoreCommand,
-		//		resumeCommand,
-		//		runCommand,
 		//		specCommand,
-		//		updateCommand,
 	}
 	app.Before = func(context *cli.Context) error {
 		if context.GlobalBool("debug") {
@@ -0,0 +1,118 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/urfave/cli"
+)
+
+func newPsCmd(llcHandler ll.RunllcHandler, sf stringSubFunc) cli.Command {
+	return cli.Command{
+		Name:  "ps",
+		Usage: "ps displays the processes running inside a container",
+		ArgsUsage: sf(`<container-id> [ps options]
+
+The processes of a nabla container are the ones of its cgroup, i.e. the
+nabla-run monitors of the unikernels started by run and exec.`),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "format, f",
+				Value: "table",
+				Usage: `select one of: ` + formatOptions,
+			},
+		},
+		Action: func(context *cli.Context) error {
+			container, err := getContainer(context, llcHandler)
+			if err != nil {
+				return err
+			}
+
+			pids, err := container.Processes()
+			if err != nil {
+				return err
+			}
+
+			switch context.String("format") {
+			case "table":
+			case "json":
+				return json.NewEncoder(os.Stdout).Encode(pids)
+			default:
+				return fmt.Errorf("invalid format option")
+			}
+
+			// [1:] is to remove the container id, ex:
+			// context.Args(): [container_id ps_arg1 ps_arg2 ...]
+			// psArgs:         [ps_arg1 ps_arg2 ...]
+			psArgs := context.Args()[1:]
+			if len(psArgs) == 0 {
+				psArgs = []string{"-ef"}
+			}
+
+			cmd := exec.Command("ps", psArgs...)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%s: %s", err, output)
+			}
+
+			lines := strings.Split(string(output), "\n")
+			pidIndex, err := getPidIndex(lines[0])
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(lines[0])
+			for _, line := range lines[1:] {
+				if len(line) == 0 {
+					continue
+				}
+				fields := strings.Fields(line)
+				p, err := strconv.Atoi(fields[pidIndex])
+				if err != nil {
+					return fmt.Errorf("unexpected pid '%s': %s", fields[pidIndex], err)
+				}
+
+				for _, pid := range pids {
+					if pid == p {
+						fmt.Println(line)
+						break
+					}
+				}
+			}
+			return nil
+		},
+		SkipArgReorder: true,
+	}
+}
+
+func getPidIndex(title string) (int, error) {
+	titles := strings.Fields(title)
+
+	pidIndex := -1
+	for i, name := range titles {
+		if name == "PID" {
+			return i, nil
+		}
+	}
+
+	return pidIndex, fmt.Errorf("couldn't find PID field in ps output")
+}
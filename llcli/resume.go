@@ -0,0 +1,41 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llcli
+
+import (
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/urfave/cli"
+)
+
+func newResumeCmd(llcHandler ll.RunllcHandler, sf stringSubFunc) cli.Command {
+	return cli.Command{
+		Name:  "resume",
+		Usage: "resumes all processes that have been previously paused",
+		ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container to be
+resumed.`,
+		Description: sf(`The resume command resumes all processes in the instance of the container.
+
+Use {{name}} list to identify instances of containers and their current status.`),
+		Action: func(context *cli.Context) error {
+			container, err := getContainer(context, llcHandler)
+			if err != nil {
+				return err
+			}
+			return container.Resume()
+		},
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2014 Docker, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package llcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/docker/docker/pkg/units"
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/urfave/cli"
+)
+
+func newUpdateCmd(llcHandler ll.RunllcHandler, sf stringSubFunc) cli.Command {
+	return cli.Command{
+		Name:      "update",
+		Usage:     "update container resource constraints",
+		ArgsUsage: `<container-id>`,
+		Description: sf(`The update command changes the resource constraints of a running container.
+
+The CPU and pids constraints are applied to the cgroup of the container. The
+guest memory of the unikernel can only be changed if the runtime supports
+ballooning, otherwise changing the memory limit fails.`),
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "resources, r",
+				Value: "",
+				Usage: `path to the file containing the resources to update or '-' to read from the standard input
+
+The accepted format is as follow (unchanged values can be omitted):
+
+{
+  "memory": {
+    "limit": 0
+  },
+  "cpu": {
+    "shares": 0,
+    "quota": 0,
+    "period": 0,
+    "cpus": "",
+    "mems": ""
+  },
+  "pids": {
+    "limit": 0
+  }
+}
+
+Note: if data is to be read from a file or the standard input, all
+other options are ignored.
+`,
+			},
+			cli.StringFlag{
+				Name:  "cpu-period",
+				Usage: "CPU CFS period to be used for hardcapping (in usecs). 0 to use system default",
+			},
+			cli.StringFlag{
+				Name:  "cpu-quota",
+				Usage: "CPU CFS hardcap limit (in usecs). Allowed cpu time in a given period",
+			},
+			cli.StringFlag{
+				Name:  "cpu-share",
+				Usage: "CPU shares (relative weight vs. other containers)",
+			},
+			cli.StringFlag{
+				Name:  "cpuset-cpus",
+				Usage: "CPU(s) to use",
+			},
+			cli.StringFlag{
+				Name:  "cpuset-mems",
+				Usage: "Memory node(s) to use",
+			},
+			cli.StringFlag{
+				Name:  "memory",
+				Usage: "Guest memory limit (in bytes)",
+			},
+			cli.IntFlag{
+				Name:  "pids-limit",
+				Usage: "Maximum number of pids allowed in the container",
+			},
+		},
+		Action: func(context *cli.Context) error {
+			container, err := getContainer(context, llcHandler)
+			if err != nil {
+				return err
+			}
+
+			r := specs.LinuxResources{}
+			if in := context.String("resources"); in != "" {
+				var (
+					f   *os.File
+					err error
+				)
+				switch in {
+				case "-":
+					f = os.Stdin
+				default:
+					f, err = os.Open(in)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+				}
+				if err := json.NewDecoder(f).Decode(&r); err != nil {
+					return err
+				}
+			} else {
+				if err := parseUpdateFlags(context, &r); err != nil {
+					return err
+				}
+			}
+
+			return container.Set(container.Config().UpdateResources(&r))
+		},
+	}
+}
+
+// parseUpdateFlags sets the resources given as flags in r, leaving the others
+// unset so they are not changed.
+func parseUpdateFlags(context *cli.Context, r *specs.LinuxResources) error {
+	cpu := &specs.LinuxCPU{
+		Cpus: context.String("cpuset-cpus"),
+		Mems: context.String("cpuset-mems"),
+	}
+	for _, pair := range []struct {
+		opt  string
+		dest **uint64
+	}{
+		{"cpu-period", &cpu.Period},
+		{"cpu-share", &cpu.Shares},
+	} {
+		if val := context.String(pair.opt); val != "" {
+			v, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value for %s: %s", pair.opt, err)
+			}
+			*pair.dest = &v
+		}
+	}
+	if val := context.String("cpu-quota"); val != "" {
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for cpu-quota: %s", err)
+		}
+		cpu.Quota = &v
+	}
+	r.CPU = cpu
+
+	if val := context.String("memory"); val != "" {
+		v, err := units.RAMInBytes(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for memory: %s", err)
+		}
+		r.Memory = &specs.LinuxMemory{Limit: &v}
+	}
+	if context.IsSet("pids-limit") {
+		r.Pids = &specs.LinuxPids{Limit: int64(context.Int("pids-limit"))}
+	}
+	return nil
+}
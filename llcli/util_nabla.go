@@ -100,14 +100,18 @@ func createContainer(context *cli.Context, llcHandler ll.RunllcHandler, id strin
 	return factory.Create(id, config)
 }
 
+// rootDir returns the absolute path of the root directory of the containers.
+func rootDir(context *cli.Context) (string, error) {
+	return filepath.Abs(context.GlobalString("root"))
+}
+
 // loadFactory returns the configured factory instance for execing containers.
 func loadFactory(context *cli.Context, llcHandler ll.RunllcHandler) (libcontainer.Factory, error) {
-	root := context.GlobalString("root")
-	abs, err := filepath.Abs(root)
+	root, err := rootDir(context)
 	if err != nil {
 		return nil, err
 	}
-	return libcontainer.New(abs, llcHandler)
+	return libcontainer.New(root, llcHandler)
 }
 
 func dupStdio(process *libcontainer.Process, rootuid, rootgid int) error {
@@ -27,8 +27,33 @@ type ExecCreateInput struct {
 
 type ExecRunInput struct {
 	ExecGenericInput
+
+	// SaveState merges the given state into the persisted ExecState of the
+	// container. ExecRunFunc does not return when successful, so this is
+	// how it saves its state before exec'ing the unikernel.
+	SaveState func(*LLState) error
 }
 
 type ExecDestroyInput struct {
 	ExecGenericInput
 }
+
+type ExecBalloonInput struct {
+	ExecGenericInput
+
+	// Memory is the new guest memory of the unikernel in MB
+	Memory int64
+}
+
+type ExecExtraProcessInput struct {
+	ExecGenericInput
+
+	// Args is the command (and its arguments) of the additional process
+	Args []string
+
+	// Env is the environment of the additional process
+	Env []string
+
+	// Cwd is the working directory of the additional process
+	Cwd string
+}
@@ -29,6 +29,10 @@ type FsRunInput struct {
 	FsGenericInput
 }
 
+type FsPostStopInput struct {
+	FsGenericInput
+}
+
 type FsDestroyInput struct {
 	FsGenericInput
 }
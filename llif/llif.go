@@ -13,13 +13,16 @@ package llif
 //
 // The order of which the handlers are run are as follows:
 // Integration: Create
-// Order: FsCreateFunc, NetworkCreateFunc, ExecCreateFunc
+// Order: NetworkCreateFunc, FsCreateFunc, ExecCreateFunc
 //
 // Integration: Run
 // Order: FsRunFunc, NetworkRunFunc, ExecRunFunc
 //
-// Integration: Destroy (this is the backward order from the previous two)
-// Order: ExecDestroyFunc, NetworkDestroyFunc, FsDestroyFunc
+// Integration: Destroy
+// Order: ExecDestroyFunc, FsPostStopFunc, FsDestroyFunc, NetworkDestroyFunc
+//
+// Additional processes started against a running container (i.e. `exec`)
+// only go through ExecExtraProcessFunc, with the states of the container.
 type RunllcHandler struct {
 	FsH      FsHandler
 	NetworkH NetworkHandler
@@ -29,6 +32,10 @@ type RunllcHandler struct {
 type FsHandler interface {
 	FsCreateFunc(*FsCreateInput) (*LLState, error)
 	FsRunFunc(*FsRunInput) (*LLState, error)
+	// FsPostStopFunc is called once the container has exited, before
+	// FsDestroyFunc, i.e. to write the changes of the container back to
+	// the host.
+	FsPostStopFunc(*FsPostStopInput) (*LLState, error)
 	FsDestroyFunc(*FsDestroyInput) (*LLState, error)
 }
 
@@ -40,19 +47,32 @@ type NetworkHandler interface {
 
 type ExecHandler interface {
 	ExecCreateFunc(*ExecCreateInput) (*LLState, error)
-	// ExecRunFunc should not return unless it runs into an error
-	// TODO(runllc): Change this to possibly return state
+	// ExecRunFunc should not return unless it runs into an error, its
+	// state can be saved with ExecRunInput.SaveState
 	ExecRunFunc(*ExecRunInput) error
+	// ExecExtraProcessFunc runs an additional process against a running
+	// container. It is called within the network namespace of the
+	// container and, like ExecRunFunc, should not return unless it runs
+	// into an error.
+	ExecExtraProcessFunc(*ExecExtraProcessInput) error
 	ExecDestroyFunc(*ExecDestroyInput) (*LLState, error)
 }
 
+// ExecBalloonHandler can optionally be implemented by an ExecHandler whose
+// unikernels can change their guest memory while running (i.e. with a
+// balloon driver). Without it, the guest memory of a container can not be
+// updated.
+type ExecBalloonHandler interface {
+	ExecBalloonFunc(*ExecBalloonInput) (*LLState, error)
+}
+
 type LLState struct {
 	// Options is the map of parameters that will be stored in the config and
 	// passed along across different operations. Entries in this map set
 	// in the output of the Create phase will be present in the input of the
-	// Run phase.
-	// TODO(runllc): Need to figure out how to save state in Exec phase or is
-	// there a need to?
+	// Run phase. The options returned by the Run phase are merged into the
+	// ones persisted with the container, so they are also present in the
+	// input of the Destroy phase.
 	Options map[string]string `json:"options"`
 
 	// InMemoryObjects is the map of objects that can be shared with other handlers
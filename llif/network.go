@@ -32,3 +32,20 @@ type NetworkRunInput struct {
 type NetworkDestroyInput struct {
 	NetworkGenericInput
 }
+
+// The network handlers describe the network interface of the unikernel with
+// the TapName, Mac, IPAddress, IPMask, Addresses, Gateway and Gateway6
+// options of their state, and its static routes with the "Routes" option, a
+// JSON encoded list of NetworkRoute. The resolver settings of the unikernel,
+// if any, are the contents of a resolv.conf(5) in the "ResolvConf" option of
+// the state of NetworkCreateFunc, which the fs handler writes into the rootfs.
+
+// NetworkRoute is a static route through the network interface of the
+// unikernel.
+type NetworkRoute struct {
+	// Dst is the destination in CIDR notation
+	Dst string `json:"dst"`
+
+	// Gw is the gateway, empty for a device route
+	Gw string `json:"gw,omitempty"`
+}
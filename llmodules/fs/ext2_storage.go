@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/pkg/units"
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ext2BlockSize is the block size used to estimate the size of a tree
+	ext2BlockSize = 4096
+
+	// ext2MinHeadroom is the minimum free space of an image sized from its
+	// rootfs
+	ext2MinHeadroom = 64 << 20
+)
+
+type ext2FsHandler struct{}
+
+// NewExt2FsHandler returns a fs handler that gives the unikernel a writable
+// root, as an ext2 image built from the rootfs of the container. The writes
+// of the unikernel stay in the image, and are lost when the container is
+// deleted, except those to the writable volumes, which are written back to
+// the host by syncVolumes.
+func NewExt2FsHandler() (ll.FsHandler, error) {
+	return &ext2FsHandler{}, nil
+}
+
+func (h *ext2FsHandler) FsCreateFunc(i *ll.FsCreateInput) (*ll.LLState, error) {
+	volumes, err := listVolumes(i.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create volumes")
+	}
+	if err := writeManifests(volumes, i.ContainerRoot); err != nil {
+		return nil, errors.Wrap(err, "Unable to create volumes")
+	}
+
+	fsPath, err := createRootfsExt2(i.Config, i.ContainerRoot, i.NetworkState.Options["ResolvConf"], volumes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create rootfs ext2 image")
+	}
+
+	ret := &ll.LLState{}
+	ret.Options = map[string]string{
+		"FsPath": fsPath,
+		"FsType": "ext2",
+	}
+	if err := setVolumes(ret, volumes); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func (h *ext2FsHandler) FsRunFunc(i *ll.FsRunInput) (*ll.LLState, error) {
+	return i.FsState, nil
+}
+
+func (h *ext2FsHandler) FsPostStopFunc(i *ll.FsPostStopInput) (*ll.LLState, error) {
+	if err := syncVolumes(i.FsState, i.ContainerRoot, i.ContainerId); err != nil {
+		return nil, errors.Wrap(err, "Unable to sync volumes")
+	}
+	return i.FsState, nil
+}
+
+func (h *ext2FsHandler) FsDestroyFunc(i *ll.FsDestroyInput) (*ll.LLState, error) {
+	if err := os.RemoveAll(i.ContainerRoot); err != nil {
+		return nil, err
+	}
+	return i.FsState, nil
+}
+
+// createRootfsExt2 creates the ext2 image of the rootfs of the container,
+// with the volumes at their destinations.
+func createRootfsExt2(config *configs.Config, containerRoot, resolvConf string, volumes []volume) (string, error) {
+	rootfsPath := config.Rootfs
+	targetPath := filepath.Join(containerRoot, "rootfs.ext2")
+	if err := copyInjectedFiles(config, resolvConf); err != nil {
+		return "", err
+	}
+
+	unmount, err := mountVolumes(rootfsPath, volumes)
+	if err != nil {
+		return "", err
+	}
+	defer unmount()
+
+	size, err := ext2ImageSize(config)
+	if err != nil {
+		return "", err
+	}
+	if err := createExt2(rootfsPath, targetPath, size); err != nil {
+		os.Remove(targetPath)
+		return "", err
+	}
+	return targetPath, nil
+}
+
+// ext2ImageSize returns the size of the rootfs image from the rootfs size
+// annotation, or from the size of the rootfs.
+func ext2ImageSize(config *configs.Config) (int64, error) {
+	if val := utils.SearchLabels(config.Labels, RootfsSizeAnnotation); val != "" {
+		size, err := units.RAMInBytes(val)
+		if err != nil {
+			return 0, errors.Wrapf(err, "Invalid value for %s", RootfsSizeAnnotation)
+		}
+		return size, nil
+	}
+	return ext2SizeOf(config.Rootfs)
+}
+
+// ext2SizeOf returns the size of an image for the tree under dir: its size
+// plus half of it, and at least ext2MinHeadroom, of free space.
+func ext2SizeOf(dir string) (int64, error) {
+	used, err := treeSize(dir)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to compute size of "+dir)
+	}
+	headroom := used / 2
+	if headroom < ext2MinHeadroom {
+		headroom = ext2MinHeadroom
+	}
+	// Round up to MiB
+	return (used + headroom + 1<<20 - 1) &^ (1<<20 - 1), nil
+}
+
+// treeSize returns an estimate of the space the tree under dir takes in an
+// ext2 filesystem: the blocks of its files and directories.
+func treeSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		blocks := (fi.Size() + ext2BlockSize - 1) / ext2BlockSize
+		if blocks == 0 {
+			blocks = 1
+		}
+		size += blocks * ext2BlockSize
+		return nil
+	})
+	return size, err
+}
+
+// createExt2 creates an ext2 image of size bytes at target with the contents
+// of dir.
+func createExt2(dir, target string, size int64) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// The image is sparse, only the blocks in use take space
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("mke2fs", "-q", "-F", "-t", "ext2",
+		"-d", dir, target, strconv.FormatInt(size/1024, 10)+"k")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to run mke2fs command: %s", out)
+	}
+	return nil
+}
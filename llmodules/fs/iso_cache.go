@@ -0,0 +1,297 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/pkg/units"
+	"github.com/nabla-containers/runnc/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ISOCacheSizeEnv is the environment variable with the maximum size of
+	// the ISO cache, e.g. "10G". A size of 0 disables the cache.
+	ISOCacheSizeEnv = "RUNNC_ISO_CACHE_SIZE"
+
+	// ISOCacheDir is the directory of the cache in the root of the runtime
+	ISOCacheDir = ".isocache"
+
+	defaultISOCacheSize = 10 << 30
+
+	// ficlone is the FICLONE ioctl, see ioctl_ficlone(2)
+	ficlone = 0x40049409
+)
+
+// isoCache is a content addressed cache of rootfs ISOs shared by the
+// containers of a runtime root. Its layout is:
+//
+//	lock                    flock(2)ed by every operation on the cache
+//	images/<digest>/rootfs.iso
+//	images/<digest>/rootfs.iso.slots
+//	images/<digest>/refs/<container-id>
+//	tmp/                    ISOs being built
+//
+// An image is placed into the root of every container that uses it, and has
+// a reference for each of them. An image without slots is linked, and an
+// image with slots is copied, as a reflink where the filesystem supports it,
+// since the slots of the copy are then filled by the container. Images without references are kept for
+// later containers, and evicted in LRU order when the cache grows over its
+// maximum size. The mtime of the image directory is the time of last use.
+type isoCache struct {
+	dir     string
+	maxSize int64
+}
+
+// newISOCache returns the ISO cache of the runtime root of containerRoot. A
+// cache with a maximum size of 0 is disabled: no images are added to it, and
+// the images left are evicted once they are released.
+func newISOCache(containerRoot string) (*isoCache, error) {
+	maxSize := int64(defaultISOCacheSize)
+	if val := os.Getenv(ISOCacheSizeEnv); val != "" {
+		v, err := units.RAMInBytes(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid value for %s", ISOCacheSizeEnv)
+		}
+		maxSize = v
+	}
+	c := &isoCache{
+		dir:     filepath.Join(filepath.Dir(containerRoot), ISOCacheDir),
+		maxSize: maxSize,
+	}
+	for _, d := range []string{c.imagesDir(), c.tmpDir()} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *isoCache) enabled() bool {
+	return c.maxSize > 0
+}
+
+func (c *isoCache) imagesDir() string {
+	return filepath.Join(c.dir, "images")
+}
+
+func (c *isoCache) tmpDir() string {
+	return filepath.Join(c.dir, "tmp")
+}
+
+func (c *isoCache) imageDir(digest string) string {
+	return filepath.Join(c.imagesDir(), digest)
+}
+
+func (c *isoCache) lock() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(c.dir, "lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Closing the file releases the lock
+	return func() { f.Close() }, nil
+}
+
+// get places the image with the given digest at target and takes a
+// reference to it for the container id. On a miss, the image is built by
+// calling build with the path to write it to. The build runs without the lock
+// held, so that creates of other images are not serialized behind it.
+func (c *isoCache) get(digest, id, target string, build func(string) error) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(c.imageDir(digest)); err == nil {
+		err = c.use(digest, id, target)
+		unlock()
+		return err
+	}
+	unlock()
+
+	tmp, err := ioutil.TempDir(c.tmpDir(), digest)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := build(filepath.Join(tmp, "rootfs.iso")); err != nil {
+		return err
+	}
+	if err := os.Chmod(filepath.Join(tmp, "rootfs.iso"), 0444); err != nil {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(tmp, "refs"), 0700); err != nil {
+		return err
+	}
+
+	if unlock, err = c.lock(); err != nil {
+		return err
+	}
+	defer unlock()
+	// Another create might have built the same image in the meantime
+	if _, err := os.Stat(c.imageDir(digest)); os.IsNotExist(err) {
+		if err := os.Rename(tmp, c.imageDir(digest)); err != nil {
+			return err
+		}
+	}
+	if err := c.use(digest, id, target); err != nil {
+		return err
+	}
+	return c.gc()
+}
+
+// use places the image and its slots at target and references it. It must be
+// called with the lock held.
+func (c *isoCache) use(digest, id, target string) error {
+	dir := c.imageDir(digest)
+	src := filepath.Join(dir, "rootfs.iso")
+	slots, err := readISOSlots(src)
+	if err != nil {
+		return err
+	}
+	place := linkImage
+	if len(slots) > 0 {
+		place = copyImage
+	}
+	if err := place(src, target); err != nil {
+		return errors.Wrap(err, "Unable to link cached rootfs ISO")
+	}
+	if err := writeISOSlots(target, slots); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "refs", id), nil, 0600); err != nil {
+		os.Remove(target)
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(dir, now, now)
+}
+
+// release drops the reference of the container id to the image with the
+// given digest, and evicts images if the cache is over its size.
+func (c *isoCache) release(digest, id string) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(filepath.Join(c.imageDir(digest), "refs", id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.gc()
+}
+
+type cachedImage struct {
+	dir     string
+	size    int64
+	lastUse time.Time
+	refs    int
+}
+
+// gc evicts the least recently used images without references until the
+// cache fits in its maximum size. It must be called with the lock held.
+func (c *isoCache) gc() error {
+	entries, err := ioutil.ReadDir(c.imagesDir())
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	var unused []cachedImage
+	for _, e := range entries {
+		img := cachedImage{
+			dir:     filepath.Join(c.imagesDir(), e.Name()),
+			lastUse: e.ModTime(),
+		}
+		fi, err := os.Stat(filepath.Join(img.dir, "rootfs.iso"))
+		if err != nil {
+			// Not a complete image, it can not be used
+			if err := os.RemoveAll(img.dir); err != nil {
+				return err
+			}
+			continue
+		}
+		img.size = fi.Size()
+		refs, err := ioutil.ReadDir(filepath.Join(img.dir, "refs"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		img.refs = len(refs)
+
+		total += img.size
+		if img.refs == 0 {
+			unused = append(unused, img)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		return unused[i].lastUse.Before(unused[j].lastUse)
+	})
+	for _, img := range unused {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.RemoveAll(img.dir); err != nil {
+			return err
+		}
+		total -= img.size
+	}
+	return nil
+}
+
+// linkImage places the read-only image src at dst, preferably as a hard link,
+// then as a reflink, and as a copy if the filesystem supports neither.
+func linkImage(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	if err := cloneFile(src, dst, 0444); err == nil {
+		return nil
+	}
+	return utils.Copy(dst, src)
+}
+
+// copyImage places a writable copy of the image src at dst, as a reflink if
+// the filesystem supports it.
+func copyImage(src, dst string) error {
+	if err := cloneFile(src, dst, 0644); err == nil {
+		return nil
+	}
+	if err := utils.Copy(dst, src); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0644)
+}
+
+func cloneFile(src, dst string, perm os.FileMode) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, d.Fd(), ficlone, s.Fd())
+	err = d.Close()
+	if errno != 0 {
+		err = fmt.Errorf("FICLONE %s: %v", dst, errno)
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
@@ -1,14 +1,20 @@
 package fs
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/nabla-containers/runnc/libcontainer/configs"
 	ll "github.com/nabla-containers/runnc/llif"
 	"github.com/nabla-containers/runnc/nabla-lib/storage"
-	"github.com/nabla-containers/runnc/utils"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
 type iSOFsHandler struct{}
@@ -18,14 +24,35 @@ func NewISOFsHandler() (ll.FsHandler, error) {
 }
 
 func (h *iSOFsHandler) FsCreateFunc(i *ll.FsCreateInput) (*ll.LLState, error) {
-	fsPath, err := createRootfsISO(i.Config, i.ContainerRoot)
+	ret := &ll.LLState{}
+	ret.Options = map[string]string{}
+
+	volumes, err := listVolumes(i.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create volumes")
+	}
+	for _, v := range volumes {
+		if !v.Readonly {
+			log.Warningf("The rootfs is an ISO, the volume %s is read-only for the unikernel, "+
+				"use the ext2 rootfs type to write to it", v.Destination)
+		}
+	}
+
+	cache, err := newISOCache(i.ContainerRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to open ISO cache")
+	}
+
+	fsPath, digest, err := createRootfsISO(i.Config, i.ContainerId, i.ContainerRoot,
+		i.NetworkState.Options["ResolvConf"], volumes, cache)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to create rootfs ISO")
 	}
 
-	ret := &ll.LLState{}
-	ret.Options = map[string]string{
-		"FsPath": fsPath,
+	ret.Options["FsPath"] = fsPath
+	ret.Options["FsType"] = "iso"
+	if digest != "" {
+		ret.Options["ISODigest"] = digest
 	}
 
 	return ret, nil
@@ -35,33 +62,142 @@ func (h *iSOFsHandler) FsRunFunc(i *ll.FsRunInput) (*ll.LLState, error) {
 	return i.FsState, nil
 }
 
+func (h *iSOFsHandler) FsPostStopFunc(i *ll.FsPostStopInput) (*ll.LLState, error) {
+	return i.FsState, nil
+}
+
 func (h *iSOFsHandler) FsDestroyFunc(i *ll.FsDestroyInput) (*ll.LLState, error) {
+	if digest := i.FsState.Options["ISODigest"]; digest != "" {
+		cache, err := newISOCache(i.ContainerRoot)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to open ISO cache")
+		}
+		if err := cache.release(digest, i.ContainerId); err != nil {
+			return nil, errors.Wrap(err, "Unable to release cached rootfs ISO")
+		}
+	}
 	if err := os.RemoveAll(i.ContainerRoot); err != nil {
 		return nil, err
 	}
 	return i.FsState, nil
 }
 
-func createRootfsISO(config *configs.Config, containerRoot string) (string, error) {
+// isoSlotsSuffix is the suffix of the file next to an ISO that lists its
+// slots.
+const isoSlotsSuffix = ".slots"
+
+// createRootfsISO creates the rootfs ISO of the container, with the resolver
+// settings resolvConf and the volumes at their destinations, and returns its
+// path and, if it comes from the cache, the digest of the cached image. The
+// injected files, which differ between the containers, are slots of the
+// image: they are left out of the digest, and filled once the image is in
+// containerRoot.
+func createRootfsISO(config *configs.Config, id, containerRoot, resolvConf string,
+	volumes []volume, cache *isoCache) (string, string, error) {
 	rootfsPath := config.Rootfs
 	targetISOPath := filepath.Join(containerRoot, "rootfs.iso")
+
+	injected, err := injectedContents(config, resolvConf)
+	if err != nil {
+		return "", "", err
+	}
+	// genisoimage makes no slots, the injected files go into the rootfs
+	if os.Getenv(storage.UseGenisoimageEnv) != "" {
+		if err := copyInjectedFiles(config, resolvConf); err != nil {
+			return "", "", err
+		}
+		injected = nil
+	}
+	var slots []string
+	for dest := range injected {
+		slots = append(slots, dest)
+	}
+	sort.Strings(slots)
 	if err := os.MkdirAll(filepath.Join(rootfsPath, "/etc"), 0755); err != nil {
-		return "", errors.Wrap(err, "Unable to create "+filepath.Join(rootfsPath, "/etc"))
-	}
-	for _, mount := range config.Mounts {
-		if (mount.Destination == "/etc/resolv.conf") ||
-			(mount.Destination == "/etc/hosts") ||
-			(mount.Destination == "/etc/hostname") {
-			dest := filepath.Join(rootfsPath, mount.Destination)
-			source := mount.Source
-			if err := utils.Copy(dest, source); err != nil {
-				return "", errors.Wrap(err, "Unable to copy "+source+" to "+dest)
-			}
+		return "", "", errors.Wrap(err, "Unable to create "+filepath.Join(rootfsPath, "/etc"))
+	}
+
+	unmount, err := mountVolumes(rootfsPath, volumes)
+	if err != nil {
+		return "", "", err
+	}
+	defer unmount()
+
+	build := func(target string) error {
+		var isoSlots []storage.IsoSlot
+		var err error
+		if len(slots) == 0 {
+			_, err = storage.CreateIso(rootfsPath, &target)
+		} else {
+			isoSlots, err = storage.CreateIsoWithSlots(rootfsPath, target, slots)
+		}
+		if err != nil {
+			return errors.Wrap(err, "Error creating iso from rootfs")
+		}
+		return writeISOSlots(target, isoSlots)
+	}
+
+	if !cache.enabled() {
+		if err := build(targetISOPath); err != nil {
+			return "", "", err
+		}
+		if err := fillISOSlots(targetISOPath, injected); err != nil {
+			return "", "", err
 		}
+		return targetISOPath, "", nil
 	}
-	_, err := storage.CreateIso(rootfsPath, &targetISOPath)
+
+	treeDigest, err := storage.IsoTreeDigest(rootfsPath, slots)
 	if err != nil {
-		return "", errors.Wrap(err, "Error creating iso from rootfs")
+		return "", "", errors.Wrap(err, "Unable to compute digest of rootfs")
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%s\n", slots, treeDigest)
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	if err := cache.get(digest, id, targetISOPath, build); err != nil {
+		return "", "", err
+	}
+	if err := fillISOSlots(targetISOPath, injected); err != nil {
+		if err := cache.release(digest, id); err != nil {
+			log.Warningf("Unable to release cached rootfs ISO: %v", err)
+		}
+		return "", "", err
+	}
+	return targetISOPath, digest, nil
+}
+
+// fillISOSlots writes the contents of the injected files to the slots of the
+// ISO image.
+func fillISOSlots(image string, injected map[string][]byte) error {
+	slots, err := readISOSlots(image)
+	if err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		if err := storage.WriteIsoSlot(image, slot, injected[slot.Path]); err != nil {
+			return errors.Wrap(err, "Unable to write "+slot.Path+" to the rootfs ISO")
+		}
+	}
+	return nil
+}
+
+func writeISOSlots(image string, slots []storage.IsoSlot) error {
+	b, err := json.Marshal(slots)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(image+isoSlotsSuffix, b, 0600)
+}
+
+func readISOSlots(image string) ([]storage.IsoSlot, error) {
+	b, err := ioutil.ReadFile(image + isoSlotsSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read the slots of "+image)
+	}
+	var slots []storage.IsoSlot
+	if err := json.Unmarshal(b, &slots); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse the slots of "+image)
 	}
-	return targetISOPath, nil
+	return slots, nil
 }
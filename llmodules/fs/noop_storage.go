@@ -21,6 +21,10 @@ func (h *noopFsHandler) FsRunFunc(i *ll.FsRunInput) (*ll.LLState, error) {
 	return i.FsState, nil
 }
 
+func (h *noopFsHandler) FsPostStopFunc(i *ll.FsPostStopInput) (*ll.LLState, error) {
+	return i.FsState, nil
+}
+
 func (h *noopFsHandler) FsDestroyFunc(i *ll.FsDestroyInput) (*ll.LLState, error) {
 	if err := os.RemoveAll(i.ContainerRoot); err != nil {
 		return nil, err
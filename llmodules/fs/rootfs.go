@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	"github.com/nabla-containers/runnc/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RootfsTypeAnnotation selects the fs handler of the container, see
+	// NewSelectFsHandler.
+	RootfsTypeAnnotation = "nabla-containers.runnc.rootfs.type"
+
+	// RootfsSizeAnnotation is the size of the writable rootfs image of the
+	// container, e.g. "1G".
+	RootfsSizeAnnotation = "nabla-containers.runnc.rootfs.size"
+)
+
+// injectedFiles are the files of the host that are copied into the rootfs of
+// the container.
+var injectedFiles = []string{"/etc/resolv.conf", "/etc/hosts", "/etc/hostname"}
+
+// copyInjectedFiles copies the injected files mounted in the container into
+// its rootfs. resolvConf, the resolver settings of the network handler, if
+// any, replaces the /etc/resolv.conf of the rootfs, so that the unikernel
+// gets one even when none is mounted.
+func copyInjectedFiles(config *configs.Config, resolvConf string) error {
+	rootfsPath := config.Rootfs
+	if err := os.MkdirAll(filepath.Join(rootfsPath, "/etc"), 0755); err != nil {
+		return errors.Wrap(err, "Unable to create "+filepath.Join(rootfsPath, "/etc"))
+	}
+
+	for _, mount := range config.Mounts {
+		if !isInjectedFile(mount.Destination) {
+			continue
+		}
+		dest := filepath.Join(rootfsPath, mount.Destination)
+		source := mount.Source
+		if err := utils.Copy(dest, source); err != nil {
+			return errors.Wrap(err, "Unable to copy "+source+" to "+dest)
+		}
+	}
+
+	if resolvConf != "" {
+		dest := filepath.Join(rootfsPath, "/etc/resolv.conf")
+		if err := ioutil.WriteFile(dest, []byte(resolvConf), 0644); err != nil {
+			return errors.Wrap(err, "Unable to write "+dest)
+		}
+	}
+	return nil
+}
+
+// injectedContents returns the contents of the injected files mounted in the
+// container, by destination, with resolvConf, if any, as /etc/resolv.conf.
+func injectedContents(config *configs.Config, resolvConf string) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	for _, mount := range config.Mounts {
+		if !isInjectedFile(mount.Destination) {
+			continue
+		}
+		data, err := ioutil.ReadFile(mount.Source)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read "+mount.Source)
+		}
+		contents[mount.Destination] = data
+	}
+	if resolvConf != "" {
+		contents["/etc/resolv.conf"] = []byte(resolvConf)
+	}
+	return contents, nil
+}
+
+func isInjectedFile(dest string) bool {
+	for _, f := range injectedFiles {
+		if dest == f {
+			return true
+		}
+	}
+	return false
+}
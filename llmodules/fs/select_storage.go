@@ -0,0 +1,94 @@
+package fs
+
+import (
+	"fmt"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type selectFsHandler struct {
+	handlers map[string]ll.FsHandler
+	def      string
+}
+
+// NewSelectFsHandler returns a fs handler that delegates to one of handlers,
+// chosen per container by the RootfsTypeAnnotation, or def if it is not set.
+// Read-only rootfs always use the "iso" handler. The name of the handler is
+// kept as the FsType of the container.
+func NewSelectFsHandler(handlers map[string]ll.FsHandler, def string) (ll.FsHandler, error) {
+	if _, ok := handlers[def]; !ok {
+		return nil, fmt.Errorf("no fs handler for the default rootfs type %q", def)
+	}
+	return &selectFsHandler{handlers: handlers, def: def}, nil
+}
+
+func (h *selectFsHandler) handler(fsType string) (ll.FsHandler, error) {
+	if fsType == "" {
+		fsType = h.def
+	}
+	handler, ok := h.handlers[fsType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported rootfs type %q", fsType)
+	}
+	return handler, nil
+}
+
+func (h *selectFsHandler) FsCreateFunc(i *ll.FsCreateInput) (*ll.LLState, error) {
+	fsType := utils.SearchLabels(i.Config.Labels, RootfsTypeAnnotation)
+	if fsType == "" {
+		fsType = h.def
+	}
+	// The ISO is the read-only image format
+	if i.Config.Readonly && fsType != "iso" {
+		if _, ok := h.handlers["iso"]; !ok {
+			return nil, fmt.Errorf("the rootfs is read-only, but there is no iso fs handler")
+		}
+		log.Warningf("The rootfs is read-only, using an iso rootfs instead of %s", fsType)
+		fsType = "iso"
+	}
+	handler, err := h.handler(fsType)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := handler.FsCreateFunc(i)
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		ret = &ll.LLState{}
+	}
+	if ret.Options == nil {
+		ret.Options = map[string]string{}
+	}
+	if ret.Options["FsType"] == "" {
+		ret.Options["FsType"] = fsType
+	}
+	return ret, nil
+}
+
+func (h *selectFsHandler) FsRunFunc(i *ll.FsRunInput) (*ll.LLState, error) {
+	handler, err := h.handler(i.FsState.Options["FsType"])
+	if err != nil {
+		return nil, err
+	}
+	return handler.FsRunFunc(i)
+}
+
+func (h *selectFsHandler) FsPostStopFunc(i *ll.FsPostStopInput) (*ll.LLState, error) {
+	handler, err := h.handler(i.FsState.Options["FsType"])
+	if err != nil {
+		return nil, err
+	}
+	return handler.FsPostStopFunc(i)
+}
+
+func (h *selectFsHandler) FsDestroyFunc(i *ll.FsDestroyInput) (*ll.LLState, error) {
+	handler, err := h.handler(i.FsState.Options["FsType"])
+	if err != nil {
+		return nil, err
+	}
+	return handler.FsDestroyFunc(i)
+}
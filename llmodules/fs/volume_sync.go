@@ -0,0 +1,337 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// conflictSuffix is appended to the name of a file changed both on the host
+// and in the container. The host keeps its version, and the version of the
+// container is written next to it with this suffix.
+const conflictSuffix = ".nabla-conflict"
+
+// unsyncedDir is the directory of the runtime root where the rootfs images
+// with volumes that can not be synced are kept, by container, so that they
+// are not deleted with the container.
+const unsyncedDir = "unsynced-volumes"
+
+// volumeEntry is the metadata of a file in a volume, used to find out which
+// side changed it since the volume was created. Only the times of the regular
+// files are kept, which debugfs restores, with the second resolution of ext2.
+type volumeEntry struct {
+	Mode   os.FileMode `json:"mode"`
+	Uid    uint32      `json:"uid"`
+	Gid    uint32      `json:"gid"`
+	Size   int64       `json:"size,omitempty"`
+	Mtime  int64       `json:"mtime,omitempty"`
+	Target string      `json:"target,omitempty"`
+}
+
+func (e volumeEntry) equal(o volumeEntry) bool {
+	if e.Mode != o.Mode || e.Uid != o.Uid || e.Gid != o.Gid {
+		return false
+	}
+	return e.Size == o.Size && e.Mtime == o.Mtime && e.Target == o.Target
+}
+
+// scanVolume returns the entries of the tree under dir by relative path.
+func scanVolume(dir string) (map[string]volumeEntry, error) {
+	entries := map[string]volumeEntry{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		e := volumeEntry{Mode: fi.Mode(), Uid: st.Uid, Gid: st.Gid}
+		switch {
+		case fi.Mode().IsRegular():
+			e.Size = fi.Size()
+			e.Mtime = fi.ModTime().Unix()
+		case fi.Mode()&os.ModeSymlink != 0:
+			if e.Target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		entries[rel] = e
+		return nil
+	})
+	return entries, err
+}
+
+// writeVolumeManifest records the entries of the source of a writable volume
+// at the time the rootfs image is created.
+func writeVolumeManifest(v volume) error {
+	entries, err := scanVolume(v.Source)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(v.Manifest, b, 0600)
+}
+
+func readVolumeManifest(v volume) (map[string]volumeEntry, error) {
+	b, err := ioutil.ReadFile(v.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]volumeEntry{}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// syncVolumes writes the changes of the container id to its writable
+// volumes, in its ext2 rootfs image, back to their sources on the host. The
+// image of a container with volumes that can not be synced, i.e. without
+// debugfs or with a missing manifest, is the only copy of what the container
+// wrote: it is moved out of containerRoot, and the error gives its path.
+func syncVolumes(state *ll.LLState, containerRoot, id string) error {
+	if state.Options["Volumes"] == "" {
+		return nil
+	}
+	var volumes []volume
+	if err := json.Unmarshal([]byte(state.Options["Volumes"]), &volumes); err != nil {
+		return errors.Wrap(err, "Unable to parse volumes")
+	}
+	image := state.Options["FsPath"]
+
+	var failed []string
+	if err := checkExt2(image); err != nil {
+		failed = append(failed, err.Error())
+	} else {
+		for _, v := range volumes {
+			if err := syncVolume(image, v); err != nil {
+				failed = append(failed, fmt.Sprintf("volume %s to %s: %v", v.Destination, v.Source, err))
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	msg := strings.Join(failed, "; ")
+	path, err := keepImage(image, volumes, containerRoot, id)
+	if err != nil {
+		msg += fmt.Sprintf(", and unable to keep the rootfs image: %v", err)
+	} else {
+		msg += ", the rootfs image is kept in " + path
+	}
+	log.Warning("Unable to sync " + msg)
+	return errors.New(msg)
+}
+
+// keepImage moves the rootfs image of the container id, and the manifests of
+// its volumes, to the unsyncedDir next to containerRoot, and returns the new
+// path of the image.
+func keepImage(image string, volumes []volume, containerRoot, id string) (string, error) {
+	dir := filepath.Join(filepath.Dir(containerRoot), unsyncedDir, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filepath.Base(image))
+	if err := os.Rename(image, path); err != nil {
+		return "", err
+	}
+	for _, v := range volumes {
+		err := os.Rename(v.Manifest, filepath.Join(dir, filepath.Base(v.Manifest)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Warningf("Unable to keep the manifest of %s: %v", v.Destination, err)
+		}
+	}
+	return path, nil
+}
+
+// checkExt2 checks the ext2 image path, and fixes it if needed, since the
+// unikernel may have been killed before writing it back cleanly.
+func checkExt2(path string) error {
+	cmd := exec.Command("e2fsck", "-f", "-p", path)
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// 1 and 2 are errors that were fixed
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() <= 2 {
+			return nil
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Unable to check the rootfs image: %s", out)
+	}
+	return nil
+}
+
+// syncVolume extracts the volume from the ext2 rootfs image and applies the
+// changes of the container to its source, comparing both sides with the
+// manifest:
+//
+//   - a file changed only by the container is copied to the source,
+//   - a file deleted only by the container is removed from the source,
+//   - a file changed on both sides is kept as is in the source, and the
+//     version of the container is written next to it with conflictSuffix.
+//
+// Files that can not be written, i.e. for lack of permissions on the
+// source, are logged and skipped.
+func syncVolume(image string, v volume) error {
+	manifest, err := readVolumeManifest(v)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read volume manifest")
+	}
+
+	tmp, err := ioutil.TempDir(filepath.Dir(image), "sync")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// rdump extracts the directory of the volume into tmp
+	cmd := exec.Command("debugfs", "-R",
+		fmt.Sprintf("rdump \"%s\" \"%s\"", filepath.Clean(v.Destination), tmp), image)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to run debugfs command: %s", out)
+	}
+	dump := filepath.Join(tmp, filepath.Base(v.Destination))
+	if _, err := os.Lstat(dump); err != nil {
+		return errors.Wrapf(err, "Unable to extract the volume: %s", out)
+	}
+
+	guest, err := scanVolume(dump)
+	if err != nil {
+		return err
+	}
+	host, err := scanVolume(v.Source)
+	if err != nil {
+		return err
+	}
+
+	// Parents sort before their children
+	var changed []string
+	for rel, g := range guest {
+		if orig, ok := manifest[rel]; !ok || !g.equal(orig) {
+			changed = append(changed, rel)
+		}
+	}
+	sort.Strings(changed)
+	for _, rel := range changed {
+		g := guest[rel]
+		orig, inOrig := manifest[rel]
+		h, inHost := host[rel]
+		dst := filepath.Join(v.Source, rel)
+
+		if inHost && h.equal(g) {
+			continue
+		}
+		if hostChanged := inOrig != inHost || (inHost && !h.equal(orig)); hostChanged {
+			if g.Mode.IsDir() {
+				continue
+			}
+			log.Warningf("%s changed in the container and on the host, keeping the host version", dst)
+			dst += conflictSuffix
+		} else if inHost && h.Mode&os.ModeType != g.Mode&os.ModeType {
+			if err := os.RemoveAll(dst); err != nil {
+				log.Warningf("Unable to remove %s: %v", dst, err)
+				continue
+			}
+		}
+		if err := restoreEntry(filepath.Join(dump, rel), dst, g); err != nil {
+			log.Warningf("Unable to sync %s: %v", dst, err)
+		}
+	}
+
+	// Children sort before their parents
+	var deleted []string
+	for rel := range manifest {
+		if _, ok := guest[rel]; !ok {
+			deleted = append(deleted, rel)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+	for _, rel := range deleted {
+		h, inHost := host[rel]
+		if !inHost || !h.equal(manifest[rel]) {
+			continue
+		}
+		// Directories with new files of the host are kept
+		if err := os.Remove(filepath.Join(v.Source, rel)); err != nil && !h.Mode.IsDir() {
+			log.Warningf("Unable to remove %s: %v", filepath.Join(v.Source, rel), err)
+		}
+	}
+	return nil
+}
+
+// restoreEntry writes the file src, extracted from the image, to dst with
+// the metadata of e.
+func restoreEntry(src, dst string, e volumeEntry) error {
+	switch {
+	case e.Mode.IsDir():
+		if err := os.Mkdir(dst, e.Mode.Perm()); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case e.Mode&os.ModeSymlink != 0:
+		os.Remove(dst)
+		if err := os.Symlink(e.Target, dst); err != nil {
+			return err
+		}
+		return os.Lchown(dst, int(e.Uid), int(e.Gid))
+	case e.Mode.IsRegular():
+		if err := copyFileContents(src, dst); err != nil {
+			return err
+		}
+	default:
+		log.Warningf("Skipping special file %s", dst)
+		return nil
+	}
+
+	if err := os.Chown(dst, int(e.Uid), int(e.Gid)); err != nil {
+		return err
+	}
+	// After the chown, which clears the setuid and setgid bits
+	if err := os.Chmod(dst, e.Mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	if !e.Mode.IsRegular() {
+		return nil
+	}
+	mtime := time.Unix(e.Mtime, 0)
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+func copyFileContents(src, dst string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(d, s); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
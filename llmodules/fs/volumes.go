@@ -0,0 +1,163 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/symlink"
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+	ll "github.com/nabla-containers/runnc/llif"
+	spec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// specialMounts are the mountpoints of the kernel filesystems, that have no
+// meaning for the unikernel.
+var specialMounts = []string{"/dev", "/proc", "/sys"}
+
+// volume is a bind mount of a directory of the container. nabla-run takes a
+// single disk, so the volumes are part of the rootfs image: their sources are
+// mounted at their destinations in the rootfs while the image is built, see
+// mountVolumes. The fs handlers list the writable volumes, JSON encoded, in
+// the "Volumes" option of their state, for syncVolumes.
+type volume struct {
+	// Source is the path of the mounted directory on the host
+	Source string `json:"source"`
+
+	// Destination is the mountpoint in the container
+	Destination string `json:"destination"`
+
+	// Readonly is set for read-only mounts
+	Readonly bool `json:"readonly,omitempty"`
+
+	// Manifest is the path of the manifest of a writable volume, see
+	// writeVolumeManifest
+	Manifest string `json:"manifest,omitempty"`
+}
+
+// listVolumes returns the volumes of the container: its bind mounts of
+// directories, other than the injected files and the special mounts.
+func listVolumes(config *configs.Config) ([]volume, error) {
+	var volumes []volume
+	for _, m := range config.Mounts {
+		if !isBindMount(m) || isInjectedFile(m.Destination) || isSpecialMount(m.Destination) {
+			continue
+		}
+		if filepath.Clean(m.Destination) == "/" {
+			log.Warningf("Bind mount of %s to / is not supported, ignoring it", m.Source)
+			continue
+		}
+		fi, err := os.Stat(m.Source)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to stat mount source")
+		}
+		if !fi.IsDir() {
+			log.Warningf("Bind mount of file %s to %s is not supported, ignoring it", m.Source, m.Destination)
+			continue
+		}
+		volumes = append(volumes, volume{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Readonly:    hasOption(m, "ro"),
+		})
+	}
+	return volumes, nil
+}
+
+// writeManifests records the contents of the writable volumes in
+// containerRoot, before the rootfs image is built.
+func writeManifests(volumes []volume, containerRoot string) error {
+	for i := range volumes {
+		v := &volumes[i]
+		if v.Readonly {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(containerRoot, "volumes"), 0700); err != nil {
+			return err
+		}
+		v.Manifest = filepath.Join(containerRoot, "volumes", fmt.Sprintf("%d.manifest", i))
+		if err := writeVolumeManifest(*v); err != nil {
+			return errors.Wrap(err, "Unable to record contents of "+v.Source)
+		}
+	}
+	return nil
+}
+
+// mountVolumes bind mounts the sources of the volumes at their destinations
+// in rootfs, and returns a function that unmounts them. The mountpoints are
+// created as needed, and stay in the rootfs, like runc does.
+func mountVolumes(rootfs string, volumes []volume) (func(), error) {
+	var mounted []string
+	unmount := func() {
+		for i := len(mounted) - 1; i >= 0; i-- {
+			if err := syscall.Unmount(mounted[i], syscall.MNT_DETACH); err != nil {
+				log.Warningf("Unable to unmount %s: %v", mounted[i], err)
+			}
+		}
+	}
+
+	for _, v := range volumes {
+		dest, err := symlink.FollowSymlinkInScope(filepath.Join(rootfs, v.Destination), rootfs)
+		if err != nil {
+			unmount()
+			return nil, errors.Wrap(err, "Unable to resolve mountpoint "+v.Destination)
+		}
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			unmount()
+			return nil, errors.Wrap(err, "Unable to create mountpoint "+dest)
+		}
+		if err := syscall.Mount(v.Source, dest, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			unmount()
+			return nil, errors.Wrapf(err, "Unable to mount %s on %s", v.Source, dest)
+		}
+		mounted = append(mounted, dest)
+	}
+	return unmount, nil
+}
+
+// setVolumes lists the writable volumes in the Volumes option of the state.
+func setVolumes(state *ll.LLState, volumes []volume) error {
+	var writable []volume
+	for _, v := range volumes {
+		if !v.Readonly {
+			writable = append(writable, v)
+		}
+	}
+	if len(writable) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(writable)
+	if err != nil {
+		return err
+	}
+	state.Options["Volumes"] = string(b)
+	return nil
+}
+
+func isBindMount(m spec.Mount) bool {
+	return m.Type == "bind" || hasOption(m, "bind") || hasOption(m, "rbind")
+}
+
+func hasOption(m spec.Mount, option string) bool {
+	for _, o := range m.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func isSpecialMount(dest string) bool {
+	dest = filepath.Clean(dest)
+	for _, p := range specialMounts {
+		if dest == p || strings.HasPrefix(dest, p+"/") {
+			return true
+		}
+	}
+	return false
+}
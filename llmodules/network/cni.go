@@ -0,0 +1,202 @@
+package network
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/nabla-containers/runnc/nabla-lib/network"
+	"github.com/opencontainers/runc/libcontainer/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	// CNIConfListAnnotation is the path of the CNI configuration list of a
+	// container, see NewCNINetworkHandler.
+	CNIConfListAnnotation = "nabla-containers.runnc.network.cni.conflist"
+
+	// CNIConfListEnv is the environment variable of the --cni-conflist
+	// flag.
+	CNIConfListEnv = "RUNNC_CNI_CONFLIST"
+
+	// CNIPathEnv is the environment variable of the --cni-path flag.
+	CNIPathEnv = "RUNNC_CNI_PATH"
+
+	defaultCNIPath = "/opt/cni/bin"
+
+	// cniNetnsDir is where the network namespaces created for the
+	// containers are kept, along with the ones of `ip netns`
+	cniNetnsDir = "/var/run/netns"
+)
+
+// CNIOptions are the options of the CNI network handler that are global to
+// the runtime.
+type CNIOptions struct {
+	// ConfList is the path of the CNI configuration list of the
+	// containers without the CNIConfListAnnotation.
+	ConfList string
+	// Path is the list of directories of the CNI plugins.
+	Path string
+}
+
+// Flags returns the global flags of the runtime that set the options.
+func (o *CNIOptions) Flags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "cni-conflist",
+			Usage:       "path of the CNI configuration list of the containers without the " + CNIConfListAnnotation + " annotation",
+			EnvVar:      CNIConfListEnv,
+			Destination: &o.ConfList,
+		},
+		cli.StringFlag{
+			Name:        "cni-path",
+			Value:       defaultCNIPath,
+			Usage:       "list of directories of the CNI plugins",
+			EnvVar:      CNIPathEnv,
+			Destination: &o.Path,
+		},
+	}
+}
+
+type cniNetworkHandler struct {
+	tap  *tapBrNetworkHandler
+	opts *CNIOptions
+}
+
+// NewCNINetworkHandler returns a network handler that plumbs the network of
+// the container itself by running the plugins of a CNI configuration list,
+// instead of relying on Docker or Kubernetes to do so. The plugins are added
+// to the network namespace of the config, or else to one created for the
+// container, and the eth0 they configure is then bridged to the tap of the
+// unikernel as in the tap handler. opts are read when a container is
+// created, i.e. once the flags of the runtime are parsed.
+func NewCNINetworkHandler(opts *CNIOptions) (ll.NetworkHandler, error) {
+	return &cniNetworkHandler{tap: &tapBrNetworkHandler{}, opts: opts}, nil
+}
+
+func (h *cniNetworkHandler) NetworkCreateFunc(i *ll.NetworkCreateInput) (_ *ll.LLState, err error) {
+	confPath := utils.SearchLabels(i.Config.Labels, CNIConfListAnnotation)
+	if confPath == "" {
+		confPath = h.opts.ConfList
+	}
+	if confPath == "" {
+		return nil, errors.Errorf("No CNI configuration list, set %s or --cni-conflist",
+			CNIConfListAnnotation)
+	}
+	// The list is kept in the state, so that the plugins are deleted with
+	// the configuration they were added with
+	confList, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read CNI configuration list")
+	}
+	conf, err := network.ParseCNIConfList(confList)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse CNI configuration list %s", confPath)
+	}
+	cniPath := h.opts.Path
+	if cniPath == "" {
+		cniPath = defaultCNIPath
+	}
+
+	options := map[string]string{
+		"CNIConfList": string(confList),
+		"CNIPath":     cniPath,
+	}
+	if i.Config.NetnsPath == "" {
+		nsPath := filepath.Join(cniNetnsDir, "runnc-"+i.ContainerId)
+		if err := network.NewNamedNetns(nsPath); err != nil {
+			return nil, errors.Wrap(err, "Unable to create network namespace")
+		}
+		defer func() {
+			if err != nil {
+				network.DeleteNamedNetns(nsPath)
+			}
+		}()
+		// The init process of the container joins the netns of the
+		// config instead of a new one
+		i.Config.NetnsPath = nsPath
+		options["CNINetns"] = nsPath
+	}
+
+	rt := cniRuntime(i.ContainerId, i.Config.NetnsPath, cniPath)
+	result, err := network.CNIAdd(conf, rt)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to add CNI network")
+	}
+	options["CNIResult"] = string(result)
+	defer func() {
+		if err != nil {
+			network.CNIDel(conf, rt, result)
+		}
+	}()
+	// The DNS of the CNI network takes precedence over the one of the tap
+	// handler
+	resolv, err := cniResolvConf(result)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to parse CNI result")
+	}
+	if resolv != "" {
+		options["ResolvConf"] = resolv
+	}
+
+	state, err := h.tap.NetworkCreateFunc(i)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range options {
+		state.Options[k] = v
+	}
+	return state, nil
+}
+
+func (h *cniNetworkHandler) NetworkRunFunc(i *ll.NetworkRunInput) (*ll.LLState, error) {
+	return h.tap.NetworkRunFunc(i)
+}
+
+func (h *cniNetworkHandler) NetworkDestroyFunc(i *ll.NetworkDestroyInput) (*ll.LLState, error) {
+	// Everything is torn down even if a step fails, and the first error is
+	// returned
+	var firstErr error
+	fail := func(err error) {
+		log.Warning(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if _, err := h.tap.NetworkDestroyFunc(i); err != nil {
+		fail(err)
+	}
+	if confList := i.NetworkState.Options["CNIConfList"]; confList != "" {
+		conf, err := network.ParseCNIConfList([]byte(confList))
+		if err != nil {
+			fail(errors.Wrap(err, "Unable to parse CNI configuration list"))
+		} else {
+			rt := cniRuntime(i.ContainerId, i.Config.NetnsPath, i.NetworkState.Options["CNIPath"])
+			result := []byte(i.NetworkState.Options["CNIResult"])
+			if err := network.CNIDel(conf, rt, result); err != nil {
+				fail(errors.Wrap(err, "Unable to delete CNI network"))
+			}
+		}
+	}
+	if nsPath := i.NetworkState.Options["CNINetns"]; nsPath != "" {
+		if err := network.DeleteNamedNetns(nsPath); err != nil {
+			fail(errors.Wrap(err, "Unable to delete network namespace"))
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return i.NetworkState, nil
+}
+
+func cniRuntime(id, nsPath, cniPath string) *network.CNIRuntime {
+	return &network.CNIRuntime{
+		Path:        filepath.SplitList(cniPath),
+		ContainerID: id,
+		NetnsPath:   nsPath,
+		IfName:      "eth0",
+	}
+}
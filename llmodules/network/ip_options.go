@@ -0,0 +1,55 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/nabla-containers/runnc/nabla-lib/network"
+)
+
+// ipOptions returns the network state options of the IP configuration taken
+// over by the unikernel. IPAddress and IPMask are the first address, IPv4 if
+// there is one, and Addresses are all of them in CIDR notation. Gateway and
+// Gateway6 are the IPv4 and IPv6 default gateways, if any, and Routes the
+// other routes.
+func ipOptions(d *network.MasterDetails) (map[string]string, error) {
+	var addrs []string
+	for _, a := range d.Addrs {
+		addrs = append(addrs, a.String())
+	}
+	addrsJSON, err := json.Marshal(addrs)
+	if err != nil {
+		return nil, err
+	}
+	cidr, _ := d.Addrs[0].Mask.Size()
+
+	options := map[string]string{
+		"IPAddress": d.Addrs[0].IP.String(),
+		"IPMask":    fmt.Sprintf("%d", cidr),
+		"Addresses": string(addrsJSON),
+		"Mac":       d.Mac,
+	}
+	if d.Gateway != nil {
+		options["Gateway"] = d.Gateway.String()
+	}
+	if d.Gateway6 != nil {
+		options["Gateway6"] = d.Gateway6.String()
+	}
+	if len(d.Routes) > 0 {
+		var routes []ll.NetworkRoute
+		for _, r := range d.Routes {
+			route := ll.NetworkRoute{Dst: r.Dst.String()}
+			if r.Gw != nil {
+				route.Gw = r.Gw.String()
+			}
+			routes = append(routes, route)
+		}
+		routesJSON, err := json.Marshal(routes)
+		if err != nil {
+			return nil, err
+		}
+		options["Routes"] = string(routesJSON)
+	}
+	return options, nil
+}
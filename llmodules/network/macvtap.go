@@ -0,0 +1,95 @@
+package network
+
+import (
+	"os"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/nabla-containers/runnc/nabla-lib/network"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type macvtapNetworkHandler struct{}
+
+// NewMacvtapNetworkHandler returns a network handler that attaches the
+// unikernel to the network of the container with a macvtap interface on top
+// of eth0. The unikernel takes the IP address of eth0, and the MAC address of
+// the macvtap interface. Unlike the tap and bridge handler, the packets of
+// the unikernel do not go through a bridge.
+func NewMacvtapNetworkHandler() (ll.NetworkHandler, error) {
+	return &macvtapNetworkHandler{}, nil
+}
+
+func (h *macvtapNetworkHandler) NetworkCreateFunc(i *ll.NetworkCreateInput) (*ll.LLState, error) {
+	resolv, err := resolvConf(i.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read resolver settings")
+	}
+
+	ret := &ll.LLState{
+		Options: map[string]string{
+			"ResolvConf": resolv,
+		},
+	}
+	return ret, nil
+}
+
+func (h *macvtapNetworkHandler) NetworkRunFunc(i *ll.NetworkRunInput) (*ll.LLState, error) {
+	// nabla-run opens the char device of the macvtap interface when given
+	// a path in /dev instead of a tap name
+	devPath := "/dev/" + nablaTapName(i.ContainerId)
+
+	masterConfig, err := masterConfigOption("eth0")
+	if err != nil {
+		return nil, err
+	}
+	details, name, err := network.CreateMacvtapInterfaceDocker("eth0", devPath)
+	if err != nil {
+		// The addresses and routes of eth0 may be gone already, they are
+		// given back when the netns outlives the container
+		if nsPath := i.Config.NetnsPath; nsPath != "" {
+			if err := restoreMasterConfig(nsPath, masterConfig); err != nil {
+				log.Warningf("Unable to restore eth0: %v", err)
+			}
+		}
+		return nil, errors.Wrap(err, "Unable to configure macvtap network")
+	}
+	options, err := ipOptions(details)
+	if err != nil {
+		return nil, err
+	}
+	options["TapName"] = devPath
+	options["IfName"] = name
+	options["MasterConfig"] = masterConfig
+
+	ret := &ll.LLState{
+		Options: options,
+	}
+
+	return ret, nil
+}
+
+func (h *macvtapNetworkHandler) NetworkDestroyFunc(i *ll.NetworkDestroyInput) (*ll.LLState, error) {
+	if devPath := i.NetworkState.Options["TapName"]; devPath != "" {
+		if err := os.Remove(devPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	// The macvtap interface and the master are in the netns of the
+	// container, which is only left behind, and possibly reused, when it
+	// was given by the config.
+	nsPath := i.Config.NetnsPath
+	if nsPath == "" {
+		return i.NetworkState, nil
+	}
+	if name := i.NetworkState.Options["IfName"]; name != "" {
+		if err := network.RemoveLinkAt(nsPath, name); err != nil {
+			return nil, errors.Wrap(err, "Unable to remove macvtap interface")
+		}
+	}
+	if err := restoreMasterConfig(nsPath, i.NetworkState.Options["MasterConfig"]); err != nil {
+		return nil, err
+	}
+	return i.NetworkState, nil
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"encoding/json"
+
+	"github.com/nabla-containers/runnc/nabla-lib/network"
+	"github.com/pkg/errors"
+)
+
+// masterConfigOption returns the configuration of the master link, JSON
+// encoded for the MasterConfig option, so that it can be restored once the
+// unikernel is gone.
+func masterConfigOption(master string) (string, error) {
+	masterConfig, err := network.GetMasterConfig(master)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to get master configuration")
+	}
+	b, err := json.Marshal(masterConfig)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// restoreMasterConfig gives the master link back the configuration of the
+// MasterConfig option val, in the network namespace at nsPath.
+func restoreMasterConfig(nsPath, val string) error {
+	if val == "" {
+		return nil
+	}
+	var masterConfig network.MasterConfig
+	if err := json.Unmarshal([]byte(val), &masterConfig); err != nil {
+		return errors.Wrap(err, "Unable to parse master configuration")
+	}
+	if err := network.RestoreMasterConfigAt(nsPath, &masterConfig); err != nil {
+		return errors.Wrap(err, "Unable to restore master configuration")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package network
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nabla-containers/runnc/libcontainer/configs"
+)
+
+const hostResolvConf = "/etc/resolv.conf"
+
+// resolvConf returns the resolver settings of the container, as the contents
+// of a resolv.conf(5): the /etc/resolv.conf mounted by the config, i.e. by
+// Docker, or else the one of the host. The loopback nameservers, which the
+// unikernel can not reach, are left out.
+func resolvConf(config *configs.Config) (string, error) {
+	path := hostResolvConf
+	for _, m := range config.Mounts {
+		if filepath.Clean(m.Destination) == "/etc/resolv.conf" {
+			path = m.Source
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if fields[0] == "nameserver" && len(fields) > 1 {
+			if ip := net.ParseIP(fields[1]); ip != nil && ip.IsLoopback() {
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// cniResolvConf returns the resolver settings of the result of a CNI ADD, as
+// the contents of a resolv.conf(5), or "" if it has no nameservers.
+func cniResolvConf(result []byte) (string, error) {
+	var r struct {
+		DNS struct {
+			Nameservers []string `json:"nameservers"`
+			Domain      string   `json:"domain"`
+			Search      []string `json:"search"`
+			Options     []string `json:"options"`
+		} `json:"dns"`
+	}
+	if err := json.Unmarshal(result, &r); err != nil {
+		return "", err
+	}
+	if len(r.DNS.Nameservers) == 0 {
+		return "", nil
+	}
+
+	var lines []string
+	for _, ns := range r.DNS.Nameservers {
+		lines = append(lines, "nameserver "+ns)
+	}
+	if r.DNS.Domain != "" {
+		lines = append(lines, "domain "+r.DNS.Domain)
+	}
+	if len(r.DNS.Search) > 0 {
+		lines = append(lines, "search "+strings.Join(r.DNS.Search, " "))
+	}
+	if len(r.DNS.Options) > 0 {
+		lines = append(lines, "options "+strings.Join(r.DNS.Options, " "))
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"fmt"
+
+	ll "github.com/nabla-containers/runnc/llif"
+	"github.com/opencontainers/runc/libcontainer/utils"
+)
+
+// NetworkTypeAnnotation selects the network handler of the container, see
+// NewSelectNetworkHandler.
+const NetworkTypeAnnotation = "nabla-containers.runnc.network.type"
+
+type selectNetworkHandler struct {
+	handlers map[string]ll.NetworkHandler
+	def      string
+}
+
+// NewSelectNetworkHandler returns a network handler that delegates to one of
+// handlers, chosen per container by the NetworkTypeAnnotation, or def if it
+// is not set. The name of the handler is kept as the NetworkType option of
+// the container.
+func NewSelectNetworkHandler(handlers map[string]ll.NetworkHandler, def string) (ll.NetworkHandler, error) {
+	if _, ok := handlers[def]; !ok {
+		return nil, fmt.Errorf("no network handler for the default network type %q", def)
+	}
+	return &selectNetworkHandler{handlers: handlers, def: def}, nil
+}
+
+func (h *selectNetworkHandler) handler(networkType string) (ll.NetworkHandler, error) {
+	if networkType == "" {
+		networkType = h.def
+	}
+	handler, ok := h.handlers[networkType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported network type %q", networkType)
+	}
+	return handler, nil
+}
+
+// withType returns the state of a handler with its network type.
+func withType(state *ll.LLState, networkType string) *ll.LLState {
+	if state == nil {
+		state = &ll.LLState{}
+	}
+	if state.Options == nil {
+		state.Options = map[string]string{}
+	}
+	state.Options["NetworkType"] = networkType
+	return state
+}
+
+func (h *selectNetworkHandler) NetworkCreateFunc(i *ll.NetworkCreateInput) (*ll.LLState, error) {
+	networkType := utils.SearchLabels(i.Config.Labels, NetworkTypeAnnotation)
+	if networkType == "" {
+		networkType = h.def
+	}
+	handler, err := h.handler(networkType)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := handler.NetworkCreateFunc(i)
+	if err != nil {
+		return nil, err
+	}
+	return withType(ret, networkType), nil
+}
+
+func (h *selectNetworkHandler) NetworkRunFunc(i *ll.NetworkRunInput) (*ll.LLState, error) {
+	networkType := i.NetworkState.Options["NetworkType"]
+	handler, err := h.handler(networkType)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := handler.NetworkRunFunc(i)
+	if err != nil {
+		return nil, err
+	}
+	if networkType == "" {
+		networkType = h.def
+	}
+	return withType(ret, networkType), nil
+}
+
+func (h *selectNetworkHandler) NetworkDestroyFunc(i *ll.NetworkDestroyInput) (*ll.LLState, error) {
+	handler, err := h.handler(i.NetworkState.Options["NetworkType"])
+	if err != nil {
+		return nil, err
+	}
+	return handler.NetworkDestroyFunc(i)
+}
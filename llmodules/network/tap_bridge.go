@@ -1,14 +1,20 @@
 package network
 
 import (
-	"fmt"
+	"net"
+	"strings"
 	"syscall"
 
 	ll "github.com/nabla-containers/runnc/llif"
 	"github.com/nabla-containers/runnc/nabla-lib/network"
+	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/pkg/errors"
 )
 
+// MasterMacAnnotation sets the MAC given to the master interface of the
+// container, which is otherwise a random locally administered one.
+const MasterMacAnnotation = "nabla-containers.runnc.network.master-mac"
+
 type tapBrNetworkHandler struct{}
 
 func NewTapBrNetworkHandler() (ll.NetworkHandler, error) {
@@ -16,6 +22,11 @@ func NewTapBrNetworkHandler() (ll.NetworkHandler, error) {
 }
 
 func (h *tapBrNetworkHandler) NetworkCreateFunc(i *ll.NetworkCreateInput) (*ll.LLState, error) {
+	resolv, err := resolvConf(i.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read resolver settings")
+	}
+
 	tapName := nablaTapName(i.ContainerId)
 	if err := network.CreateTapInterface(tapName, nil, nil); err != nil {
 		return nil, errors.Wrap(err, "Unable to create tap in NetworkCreate")
@@ -23,7 +34,8 @@ func (h *tapBrNetworkHandler) NetworkCreateFunc(i *ll.NetworkCreateInput) (*ll.L
 
 	ret := &ll.LLState{
 		Options: map[string]string{
-			"TapName": tapName,
+			"TapName":    tapName,
+			"ResolvConf": resolv,
 		},
 	}
 	return ret, nil
@@ -35,27 +47,39 @@ func (h *tapBrNetworkHandler) NetworkRunFunc(i *ll.NetworkRunInput) (*ll.LLState
 		return nil, errors.New("Unable to get tap name")
 	}
 
-	// The tap device will get the IP assigned to the k8s nabla
-	// container veth pair.
-	// XXX: This is a workaround due to an error with MacvTap, error was :
-	// Could not create /dev/tap8863: open /sys/devices/virtual/net/macvtap8863/tap8863/dev: no such file or directory
-	ipAddress, gateway, ipMask, mac, err := network.CreateTapInterfaceDocker(nablaTapName(i.ContainerId), "eth0")
+	// The tap device will get the IPs assigned to the k8s nabla container
+	// veth pair. Only eth0 is handed over, as nabla-run takes a single
+	// --net. See the macvtap network handler for an alternative without a
+	// bridge.
+	bridgeName := nablaBridgeName(tapName)
+	masterMac, err := network.RandomMac()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to generate master MAC")
+	}
+	if val := utils.SearchLabels(i.Config.Labels, MasterMacAnnotation); val != "" {
+		if masterMac, err = net.ParseMAC(val); err != nil {
+			return nil, errors.Wrapf(err, "Invalid value for %s", MasterMacAnnotation)
+		}
+	}
+	masterConfig, err := masterConfigOption("eth0")
+	if err != nil {
+		return nil, err
+	}
+	details, err := network.CreateTapInterfaceDocker(tapName, "eth0", bridgeName, masterMac)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to configure network runtime")
 	}
-	cidr, totalBits := ipMask.Size()
-	if totalBits != 32 {
-		return nil, errors.New("Unexpected IP address number of bits")
+	options, err := ipOptions(details)
+	if err != nil {
+		return nil, err
 	}
+	options["TapName"] = tapName
+	options["BridgeName"] = bridgeName
+	options["MasterMac"] = masterMac.String()
+	options["MasterConfig"] = masterConfig
 
 	ret := &ll.LLState{
-		Options: map[string]string{
-			"IPAddress": ipAddress.String(),
-			"Gateway":   gateway.String(),
-			"IPMask":    fmt.Sprintf("%d", cidr),
-			"Mac":       mac,
-			"TapName":   tapName,
-		},
+		Options: options,
 	}
 
 	return ret, nil
@@ -69,11 +93,39 @@ func (h *tapBrNetworkHandler) NetworkDestroyFunc(i *ll.NetworkDestroyInput) (*ll
 	if err := network.RemoveTapDevice(tapName); err != nil {
 		return nil, err
 	}
+
+	// The bridge and the master are in the netns of the container, which
+	// is only left behind, and possibly reused, when it was given by the
+	// config.
+	nsPath := i.Config.NetnsPath
+	if nsPath == "" {
+		return i.NetworkState, nil
+	}
+	if bridgeName := i.NetworkState.Options["BridgeName"]; bridgeName != "" {
+		if err := network.RemoveLinkAt(nsPath, bridgeName); err != nil {
+			return nil, errors.Wrap(err, "Unable to remove bridge")
+		}
+	}
+	if err := network.RemoveLinkAt(nsPath, tapName); err != nil {
+		return nil, errors.Wrap(err, "Unable to remove tap")
+	}
+	if err := restoreMasterConfig(nsPath, i.NetworkState.Options["MasterConfig"]); err != nil {
+		return nil, err
+	}
 	return i.NetworkState, nil
 }
 
 //err = network.CreateTapInterface(nablaTapName(id), nil, nil)
 
+// nablaBridgeName returns the name of the bridge of a given tap
+func nablaBridgeName(tapName string) string {
+	name := "br" + strings.TrimPrefix(tapName, "tap")
+	if len(name) > syscall.IFNAMSIZ-1 {
+		name = name[:syscall.IFNAMSIZ-1]
+	}
+	return name
+}
+
 // nablaTapName returns the tapname of a given container ID
 func nablaTapName(id string) string {
 	if len(id) < 8 {
@@ -1,6 +1,7 @@
 package nabla
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 	"github.com/nabla-containers/runnc/libcontainer/configs"
 	ll "github.com/nabla-containers/runnc/llif"
 	"github.com/nabla-containers/runnc/llruntimes/nabla/runnc-cont"
+	"github.com/opencontainers/runc/libcontainer/utils"
 	"github.com/pkg/errors"
 )
 
@@ -17,6 +19,12 @@ var (
 	NablaRunBin = NablaBinDir + "nabla-run"
 )
 
+// GuestRoutesAnnotation set to "true" tells that the unikernel of the
+// container takes the route entries of the rumprun args. Without it, the
+// static routes are left out and the netmask is widened to reach an
+// off-link gateway.
+const GuestRoutesAnnotation = "nabla-containers.runnc.guest.routes"
+
 type nablaExecHandler struct{}
 
 func NewNablaExecHandler() (ll.ExecHandler, error) {
@@ -39,6 +47,42 @@ func (h *nablaExecHandler) ExecRunFunc(i *ll.ExecRunInput) error {
 		return errors.Wrap(err, "Unable to construct nabla run args")
 	}
 
+	if i.SaveState != nil {
+		execState := &ll.LLState{
+			Options: map[string]string{
+				"NablaRunBin":  runncCont.NablaRunBin,
+				"UniKernelBin": runncCont.UniKernelBin,
+			},
+		}
+		if err := i.SaveState(execState); err != nil {
+			return errors.Wrap(err, "Unable to save exec state")
+		}
+	}
+
+	// Shouldn't return
+	return runncCont.Run()
+}
+
+// ExecExtraProcessFunc runs the additional process as a second nabla-run that
+// boots from the same rootfs disk as the container. The tap device of the
+// container is held by the running unikernel, so the additional unikernel
+// runs without networking.
+func (h *nablaExecHandler) ExecExtraProcessFunc(i *ll.ExecExtraProcessInput) error {
+	// Both unikernels would mount the writable filesystem
+	if i.FsState.Options["FsType"] == "ext2" {
+		return fmt.Errorf("additional processes can not share the writable ext2 rootfs of the container")
+	}
+
+	cfg := *i.Config
+	cfg.Args = i.Args
+	cfg.Env = i.Env
+	cfg.Cwd = i.Cwd
+
+	runncCont, err := newExtraRunncCont(i.ContainerRoot, cfg, i.FsState.Options)
+	if err != nil {
+		return errors.Wrap(err, "Unable to construct nabla run args")
+	}
+
 	// Shouldn't return
 	return runncCont.Run()
 }
@@ -48,18 +92,40 @@ func (h *nablaExecHandler) ExecDestroyFunc(i *ll.ExecDestroyInput) (*ll.LLState,
 	return ret, nil
 }
 
+func checkNablaArgs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("OCI process args are empty")
+	}
+
+	if !strings.HasSuffix(args[0], ".nabla") {
+		return fmt.Errorf("entrypoint is not a .nabla file")
+	}
+	return nil
+}
+
 func newRunncCont(containerRoot string, cfg configs.Config, networkMap map[string]string, fsMap map[string]string) (*runnc_cont.RunncCont, error) {
-	if len(cfg.Args) == 0 {
-		return nil, fmt.Errorf("OCI process args are empty")
+	if err := checkNablaArgs(cfg.Args); err != nil {
+		return nil, err
 	}
 
-	if !strings.HasSuffix(cfg.Args[0], ".nabla") {
-		return nil, fmt.Errorf("entrypoint is not a .nabla file")
+	// The network handler may leave the unikernel without networking
+	var cidr int
+	if networkMap["IPMask"] != "" {
+		var err error
+		if cidr, err = strconv.Atoi(networkMap["IPMask"]); err != nil {
+			return nil, fmt.Errorf("Unable to parse IPMask: %v", networkMap["IPMask"])
+		}
 	}
 
-	cidr, err := strconv.Atoi(networkMap["IPMask"])
+	var addresses []string
+	if networkMap["Addresses"] != "" {
+		if err := json.Unmarshal([]byte(networkMap["Addresses"]), &addresses); err != nil {
+			return nil, fmt.Errorf("Unable to parse Addresses: %v", err)
+		}
+	}
+	routes, err := parseRoutes(networkMap)
 	if err != nil {
-		return nil, fmt.Errorf("Unablae to parse IPMask: %v", cidr)
+		return nil, err
 	}
 
 	c := runnc_cont.Config{
@@ -76,6 +142,10 @@ func newRunncCont(containerRoot string, cfg configs.Config, networkMap map[strin
 		Mac:          networkMap["Mac"],
 		Gateway:      networkMap["Gateway"],
 		IPMask:       cidr,
+		Addresses:    addresses,
+		Gateway6:     networkMap["Gateway6"],
+		Routes:       routes,
+		GuestRoutes:  utils.SearchLabels(cfg.Labels, GuestRoutesAnnotation) == "true",
 	}
 
 	cont, err := runnc_cont.NewRunncCont(c)
@@ -85,3 +155,40 @@ func newRunncCont(containerRoot string, cfg configs.Config, networkMap map[strin
 
 	return cont, nil
 }
+
+// parseRoutes returns the static routes listed by the network handler, if
+// any.
+func parseRoutes(networkMap map[string]string) ([]runnc_cont.RouteConfig, error) {
+	if networkMap["Routes"] == "" {
+		return nil, nil
+	}
+	var llRoutes []ll.NetworkRoute
+	if err := json.Unmarshal([]byte(networkMap["Routes"]), &llRoutes); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse routes")
+	}
+
+	var routes []runnc_cont.RouteConfig
+	for _, r := range llRoutes {
+		routes = append(routes, runnc_cont.RouteConfig{Dst: r.Dst, Gw: r.Gw})
+	}
+	return routes, nil
+}
+
+// newExtraRunncCont returns a runnc-cont without networking for an additional
+// process of a running container.
+func newExtraRunncCont(containerRoot string, cfg configs.Config, fsMap map[string]string) (*runnc_cont.RunncCont, error) {
+	if err := checkNablaArgs(cfg.Args); err != nil {
+		return nil, err
+	}
+
+	return runnc_cont.NewRunncCont(runnc_cont.Config{
+		NablaRunBin:  NablaRunBin,
+		UniKernelBin: filepath.Join(containerRoot, cfg.Args[0]),
+		Memory:       cfg.Memory,
+		Disk:         []string{fsMap["FsPath"]},
+		WorkingDir:   cfg.Cwd,
+		Env:          cfg.Env,
+		NablaRunArgs: cfg.Args[1:],
+		Mounts:       cfg.Mounts,
+	})
+}
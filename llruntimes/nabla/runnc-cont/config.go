@@ -4,6 +4,13 @@ import (
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// RouteConfig is a static route, to Dst in CIDR notation through the gateway
+// Gw, or through the interface itself without Gw.
+type RouteConfig struct {
+	Dst string
+	Gw  string
+}
+
 // Config configuration to create a runnc-cont
 type Config struct {
 	// NablaRunBin is the path to 'nabla-run' binary.
@@ -22,6 +29,20 @@ type Config struct {
 	Gateway   string
 	Mac       string
 
+	// Addresses are the IPv4 and IPv6 addresses of the unikernel in CIDR
+	// notation. When empty, IPAddress and IPMask are the only one.
+	Addresses []string
+
+	// Gateway6 is the IPv6 default gateway, Gateway the IPv4 one.
+	Gateway6 string
+
+	// Routes are the static routes of the unikernel.
+	Routes []RouteConfig
+
+	// GuestRoutes is set when the unikernel takes the route entries of the
+	// rumprun args, see CreateRumprunArgs.
+	GuestRoutes bool
+
 	// Memory max memory size in MBs.
 	Memory int64
 
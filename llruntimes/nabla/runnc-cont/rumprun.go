@@ -22,41 +22,55 @@ package runnc_cont
 import (
 	"encoding/json"
 	"fmt"
+	"math/bits"
 	"net"
 	"strconv"
 	"strings"
 
 	"github.com/nabla-containers/runnc/nabla-lib/network"
+	log "github.com/sirupsen/logrus"
 )
 
+// rumpArgsNetwork is a net entry, which configures one address of an
+// interface. Only the first entry of an interface creates it, and only one
+// entry of each family sets the default gateway.
 type rumpArgsNetwork struct {
 	If     string `json:"if"`
-	Cloner string `json:"cloner"`
+	Cloner string `json:"cloner,omitempty"`
 	Type   string `json:"type"`
 	Method string `json:"method"`
 	Addr   string `json:"addr"`
 	Mask   string `json:"mask"`
-	Gw     string `json:"gw"`
+	Gw     string `json:"gw,omitempty"`
+}
+
+// rumpArgsRoute is a route entry, added after the addresses. A route without
+// gateway is a device route through If.
+type rumpArgsRoute struct {
+	Dst string `json:"dst"`
+	Gw  string `json:"gw,omitempty"`
+	If  string `json:"if,omitempty"`
 }
 
 type rumpArgsBlock struct {
 	Source string `json:"source"`
-	Path   string `json:"path"`
+	Path   string `json:"path,omitempty"`
 	Fstype string `json:"fstype"`
 	Mount  string `json:"mountpoint"`
 }
 
 type rumpArgs struct {
-	Cmdline string          `json:"cmdline"`
-	Net     rumpArgsNetwork `json:"net"`
-	Blk     *rumpArgsBlock  `json:"blk,omitempty"`
-	Env     []string        `json:"env,omitempty"`
-	Cwd     string          `json:"cwd,omitempty"`
-	Mem     string          `json:"mem,omitempty"`
+	Cmdline string            `json:"cmdline"`
+	Net     []rumpArgsNetwork `json:"net,omitempty"`
+	Route   []rumpArgsRoute   `json:"route,omitempty"`
+	Blk     *rumpArgsBlock    `json:"blk,omitempty"`
+	Env     []string          `json:"env,omitempty"`
+	Cwd     string            `json:"cwd,omitempty"`
+	Mem     string            `json:"mem,omitempty"`
 }
 
 // Overwrite the rumprum args marshalling since rump expects multiple env
-// variables to be passed in a weird way.
+// variables, network addresses and routes to be passed in a weird way.
 func (ra *rumpArgs) MarshalJSON() ([]byte, error) {
 	// Create duplicate env variables due to consumption method of rump that
 	// requires duplicate json keys.
@@ -78,7 +92,29 @@ func (ra *rumpArgs) MarshalJSON() ([]byte, error) {
 		addString += string(vb[1:len(vb)-1]) + ","
 	}
 
-	// Marshal rest of the struct minus Env
+	type NetAlias struct {
+		Net rumpArgsNetwork `json:"net"`
+	}
+	for _, n := range ra.Net {
+		vb, err := json.Marshal(&NetAlias{n})
+		if err != nil {
+			return nil, err
+		}
+		addString += string(vb[1:len(vb)-1]) + ","
+	}
+
+	type RouteAlias struct {
+		Route rumpArgsRoute `json:"route"`
+	}
+	for _, r := range ra.Route {
+		vb, err := json.Marshal(&RouteAlias{r})
+		if err != nil {
+			return nil, err
+		}
+		addString += string(vb[1:len(vb)-1]) + ","
+	}
+
+	// Marshal rest of the struct minus Env, Net and Route
 	type Alias rumpArgs
 	alias := &struct {
 		*Alias
@@ -86,12 +122,18 @@ func (ra *rumpArgs) MarshalJSON() ([]byte, error) {
 		Alias: (*Alias)(ra),
 	}
 
+	nets := ra.Net
+	routes := ra.Route
 	alias.Env = nil
+	alias.Net = nil
+	alias.Route = nil
 	otherBytes, err := json.Marshal(alias)
+	alias.Env = env
+	alias.Net = nets
+	alias.Route = routes
 	if err != nil {
 		return nil, err
 	}
-	alias.Env = env
 
 	// Put bytes together
 	modified := make([]byte, 0, len(otherBytes)+len(addString))
@@ -102,35 +144,57 @@ func (ra *rumpArgs) MarshalJSON() ([]byte, error) {
 	return modified, nil
 }
 
-// CreateRumprunArgs returns the cmdline string for rumprun (a json)
-func CreateRumprunArgs(ip net.IP, mask net.IPMask, gw net.IP,
-	mountPoint string, envVars []string, cwd string,
+// CreateRumprunArgs returns the cmdline string for rumprun (a json). The
+// interface gets its addresses, IPv4 and IPv6, and its default gateways. A
+// nil iface leaves the unikernel without networking. routes is set for a
+// unikernel that takes the route entries: the static routes of the interface
+// are then passed, and a gateway outside of its subnets, i.e. with a /32
+// address, is reached through a device route. Otherwise the routes are left
+// out and the mask is widened to reach the gateway, see setGateway. rumprun
+// probes the filesystem of the disk mounted at mountPoint, iso or ext2.
+func CreateRumprunArgs(iface *Interface, routes bool,
+	mountPoint string,
+	envVars []string, cwd string,
 	unikernel string, cmdargs []string) (string, error) {
 
-	// XXX: Due to bug in: https://github.com/nabla-containers/runnc/issues/40
-	// If we detect a /32 mask, we set it to 1 as a "fix", and hope we are in
-	// the same subnet... (working on a fix for mask:0)
-	cidr := strconv.Itoa(network.MaskCIDR(mask))
-	if cidr == "32" {
-		fmt.Printf("WARNING: Changing CIDR from 32 to 1 due to Issue https://github.com/nabla-containers/runnc/issues/40\n")
-		cidr = "1"
-	}
-
-	net := rumpArgsNetwork{
-		If:     "ukvmif0",
-		Cloner: "True",
-		Type:   "inet",
-		Method: "static",
-		Addr:   ip.String(),
-		Mask:   cidr,
-		Gw:     gw.String(),
-	}
-
 	cmdline := append([]string{unikernel}, cmdargs...)
 	ra := &rumpArgs{
 		Cwd:     cwd,
 		Cmdline: strings.Join(cmdline, " "),
-		Net:     net,
+	}
+
+	if iface != nil {
+		const ifName = "ukvmif0"
+		cloner := "True"
+		for _, addr := range iface.Addrs {
+			n := rumpArgsNetwork{
+				If:     ifName,
+				Cloner: cloner,
+				Type:   "inet6",
+				Method: "static",
+				Addr:   addr.IP.String(),
+				Mask:   strconv.Itoa(network.MaskCIDR(addr.Mask)),
+			}
+			if addr.IP.To4() != nil {
+				n.Type = "inet"
+			}
+			cloner = ""
+			ra.Net = append(ra.Net, n)
+		}
+
+		if !routes && len(iface.Routes) > 0 {
+			log.Warningf("The unikernel does not take routes, ignoring the %d routes of %s",
+				len(iface.Routes), ifName)
+		} else {
+			ra.addRoutes(ifName, iface.Routes)
+		}
+
+		if iface.Gateway != nil {
+			ra.setGateway(ifName, iface.Addrs, iface.Gateway, "0.0.0.0/0", routes)
+		}
+		if iface.Gateway6 != nil {
+			ra.setGateway(ifName, iface.Addrs, iface.Gateway6, "::/0", routes)
+		}
 	}
 	if mountPoint != "" {
 		block := rumpArgsBlock{
@@ -153,3 +217,97 @@ func CreateRumprunArgs(ip net.IP, mask net.IPMask, gw net.IP,
 
 	return string(b), nil
 }
+
+// addRoutes adds the static routes through ifName. The device routes go
+// first, as the gateways of the other routes may be reached through them.
+func (ra *rumpArgs) addRoutes(ifName string, routes []Route) {
+	for _, r := range routes {
+		if r.Gw == nil {
+			ra.Route = append(ra.Route, rumpArgsRoute{Dst: r.Dst.String(), If: ifName})
+		}
+	}
+	for _, r := range routes {
+		if r.Gw != nil {
+			ra.Route = append(ra.Route, rumpArgsRoute{Dst: r.Dst.String(), Gw: r.Gw.String()})
+		}
+	}
+}
+
+// setGateway sets gw as the default gateway of its family. A gateway in one of
+// the subnets of addrs is set on the net entry of that address. Otherwise,
+// with routes, it is reached through a device route on ifName, which the
+// default route then goes through. Without, the mask of the first address of
+// the family is widened until its subnet has the gateway, down to /1 as the
+// unikernel does not take a /0 (issue #40).
+func (ra *rumpArgs) setGateway(ifName string, addrs []*net.IPNet, gw net.IP, defaultDst string, routes bool) {
+	for _, addr := range addrs {
+		subnet := &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+		if !subnet.Contains(gw) || addr.IP.Equal(gw) {
+			continue
+		}
+		if n := ra.findNet(ifName, addr.IP); n != nil {
+			n.Gw = gw.String()
+			return
+		}
+	}
+
+	if !routes {
+		for _, addr := range addrs {
+			if (addr.IP.To4() == nil) != (gw.To4() == nil) {
+				continue
+			}
+			n := ra.findNet(ifName, addr.IP)
+			if n == nil {
+				continue
+			}
+			ones := commonPrefixLen(addr.IP, gw)
+			if ones < 1 {
+				ones = 1
+				log.Warningf("The gateway %s is not reachable from %s without routes", gw, addr.IP)
+			}
+			n.Mask = strconv.Itoa(ones)
+			n.Gw = gw.String()
+			return
+		}
+		return
+	}
+
+	bits := 8 * net.IPv6len
+	if gw.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+	// The static routes may already have the device route
+	gwRoute := rumpArgsRoute{Dst: fmt.Sprintf("%s/%d", gw, bits), If: ifName}
+	found := false
+	for _, r := range ra.Route {
+		found = found || r == gwRoute
+	}
+	if !found {
+		ra.Route = append(ra.Route, gwRoute)
+	}
+	ra.Route = append(ra.Route, rumpArgsRoute{Dst: defaultDst, Gw: gw.String()})
+}
+
+// findNet returns the net entry of the address ip of ifName, if any.
+func (ra *rumpArgs) findNet(ifName string, ip net.IP) *rumpArgsNetwork {
+	for i := range ra.Net {
+		if ra.Net[i].If == ifName && ra.Net[i].Addr == ip.String() {
+			return &ra.Net[i]
+		}
+	}
+	return nil
+}
+
+// commonPrefixLen returns the number of leading bits a and b, of the same
+// family, have in common.
+func commonPrefixLen(a, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		a, b = a4, b4
+	}
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return 8*i + bits.LeadingZeros8(x)
+		}
+	}
+	return 8 * len(a)
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2018, IBM
+// Author(s): Brandon Lum, Ricardo Koller, Dan Williams
+//
+// Permission to use, copy, modify, and/or distribute this software for
+// any purpose with or without fee is hereby granted, provided that the
+// above copyright notice and this permission notice appear in all
+// copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL
+// DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA
+// OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER
+// TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+// PERFORMANCE OF THIS SOFTWARE.
+
+// +build linux
+
+package runnc_cont
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &net.IPNet{IP: ip, Mask: ipNet.Mask}
+}
+
+func TestCreateRumprunArgsGateway(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		gw      string
+		routes  bool
+		network string
+	}{
+		{
+			name:    "/32 off-link",
+			addr:    "10.88.0.2/32",
+			gw:      "169.254.1.1",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"32"},"route":{"dst":"169.254.1.1/32","if":"ukvmif0"},"route":{"dst":"0.0.0.0/0","gw":"169.254.1.1"}`,
+		},
+		{
+			name:    "/32 off-link without routes",
+			addr:    "10.88.0.2/32",
+			gw:      "169.254.1.1",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"1","gw":"169.254.1.1"}`,
+		},
+		{
+			name:    "/32 off-link in the same /30 without routes",
+			addr:    "10.88.0.2/32",
+			gw:      "10.88.0.1",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"30","gw":"10.88.0.1"}`,
+		},
+		{
+			name:    "/24 on-link",
+			addr:    "10.88.0.2/24",
+			gw:      "10.88.0.1",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"24","gw":"10.88.0.1"}`,
+		},
+		{
+			name:    "/24 on-link without routes",
+			addr:    "10.88.0.2/24",
+			gw:      "10.88.0.1",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"24","gw":"10.88.0.1"}`,
+		},
+		{
+			name:    "/24 off-link",
+			addr:    "10.88.0.2/24",
+			gw:      "10.87.0.1",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"24"},"route":{"dst":"10.87.0.1/32","if":"ukvmif0"},"route":{"dst":"0.0.0.0/0","gw":"10.87.0.1"}`,
+		},
+		{
+			name:    "/24 off-link without routes",
+			addr:    "10.88.0.2/24",
+			gw:      "10.87.0.1",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"12","gw":"10.87.0.1"}`,
+		},
+		{
+			name:    "/16 on-link",
+			addr:    "10.88.0.2/16",
+			gw:      "10.88.200.1",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"16","gw":"10.88.200.1"}`,
+		},
+		{
+			name:    "/16 off-link",
+			addr:    "10.88.0.2/16",
+			gw:      "10.90.0.1",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"16"},"route":{"dst":"10.90.0.1/32","if":"ukvmif0"},"route":{"dst":"0.0.0.0/0","gw":"10.90.0.1"}`,
+		},
+		{
+			name:    "/16 off-link without routes",
+			addr:    "10.88.0.2/16",
+			gw:      "10.90.0.1",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"14","gw":"10.90.0.1"}`,
+		},
+		{
+			name:    "/128 off-link",
+			addr:    "fd00::2/128",
+			gw:      "fe80::1",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet6","method":"static","addr":"fd00::2","mask":"128"},"route":{"dst":"fe80::1/128","if":"ukvmif0"},"route":{"dst":"::/0","gw":"fe80::1"}`,
+		},
+		{
+			name:    "/64 on-link",
+			addr:    "fd00::2/64",
+			gw:      "fd00::1",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet6","method":"static","addr":"fd00::2","mask":"64","gw":"fd00::1"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iface := Interface{
+				Tap:   "tap0",
+				Addrs: []*net.IPNet{mustParseCIDR(t, tt.addr)},
+			}
+			if gw := net.ParseIP(tt.gw); gw.To4() != nil {
+				iface.Gateway = gw
+			} else {
+				iface.Gateway6 = gw
+			}
+
+			got, err := CreateRumprunArgs(&iface, tt.routes, "",
+				nil, "", "app.nabla", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "{" + tt.network + `,"cmdline":"app.nabla"}`
+			if got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateRumprunArgsRoutes(t *testing.T) {
+	iface := Interface{
+		Tap:     "tap0",
+		Addrs:   []*net.IPNet{mustParseCIDR(t, "10.88.0.2/32")},
+		Gateway: net.ParseIP("169.254.1.1"),
+		Routes: []Route{
+			{Dst: mustParseCIDR(t, "10.99.0.0/16"), Gw: net.ParseIP("169.254.1.1")},
+			{Dst: mustParseCIDR(t, "169.254.1.1/32")},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		routes  bool
+		network string
+	}{
+		{
+			// The device route to the gateway is not repeated
+			name:    "with routes",
+			routes:  true,
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"32"},"route":{"dst":"169.254.1.1/32","if":"ukvmif0"},"route":{"dst":"10.99.0.0/16","gw":"169.254.1.1"},"route":{"dst":"0.0.0.0/0","gw":"169.254.1.1"}`,
+		},
+		{
+			name:    "without routes",
+			network: `"net":{"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.88.0.2","mask":"1","gw":"169.254.1.1"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateRumprunArgs(&iface, tt.routes, "",
+				nil, "", "app.nabla", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "{" + tt.network + `,"cmdline":"app.nabla"}`
+			if got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateRumprunArgsBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		mountPoint string
+		blk        string
+	}{
+		{
+			name:       "no disk",
+			mountPoint: "",
+			blk:        "",
+		},
+		{
+			name:       "rootfs",
+			mountPoint: "/",
+			blk:        `,"blk":{"source":"etfs","path":"/dev/ld0a","fstype":"blk","mountpoint":"/"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CreateRumprunArgs(nil, false, tt.mountPoint,
+				nil, "", "app.nabla", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := `{"cmdline":"app.nabla"` + tt.blk + "}"
+			if got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
@@ -30,6 +30,30 @@ import (
 	spec "github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// Interface is the network interface of the unikernel, ukvmif0.
+type Interface struct {
+	// Tap tap device. (e.g. tap100)
+	Tap string
+
+	Mac string
+
+	// Addrs are the IPv4 and IPv6 addresses, and Gateway and Gateway6 the
+	// default gateways, if any.
+	Addrs    []*net.IPNet
+	Gateway  net.IP
+	Gateway6 net.IP
+
+	// Routes are the static routes through the interface
+	Routes []Route
+}
+
+// Route is a static route to Dst, through Gw or else through the interface
+// itself.
+type Route struct {
+	Dst *net.IPNet
+	Gw  net.IP
+}
+
 type RunncCont struct {
 	// NablaRunBin is the path to 'nabla-run' binary.
 	NablaRunBin string
@@ -39,13 +63,13 @@ type RunncCont struct {
 	// UniKernelBin is the path to 'unikernel' binary.
 	UniKernelBin string
 
-	// Tap tap device. (e.g. tap100)
-	Tap string
+	// Interface is the network interface of the unikernel, nil without
+	// networking.
+	Interface *Interface
 
-	IPAddress net.IP
-	IPMask    net.IPMask
-	Gateway   net.IP
-	Mac       string
+	// GuestRoutes is set when the unikernel takes the route entries of the
+	// rumprun args, see CreateRumprunArgs.
+	GuestRoutes bool
 
 	// Memory max memory size in MBs.
 	Memory int64
@@ -70,40 +94,22 @@ func NewRunncCont(cfg Config) (*RunncCont, error) {
 		return nil, fmt.Errorf("No disk provided")
 	}
 
-	// If network details are specified, use them, if not do usual network plumbing
-	if len(cfg.IPAddress) == 0 || len(cfg.Gateway) == 0 || len(cfg.Tap) == 0 {
-		return nil, fmt.Errorf("Insufficient network arguments set")
-	}
-	netstr := fmt.Sprintf("%s/%d", cfg.IPAddress, cfg.IPMask)
-	ipAddress, ipNet, err := net.ParseCIDR(netstr)
-	if err != nil {
-		return nil, fmt.Errorf("not a valid IP address: %s, err: %v", netstr, err)
-	}
-
-	ipMask := ipNet.Mask
-
-	gateway := net.ParseIP(cfg.Gateway)
-	if gateway == nil {
-		return nil, fmt.Errorf("not a valid gateway address: %s", cfg.Gateway)
-	}
-
-	mac := ""
-	if len(cfg.Mac) > 0 {
-		if _, err := net.ParseMAC(cfg.Mac); err != nil {
-			return nil, fmt.Errorf("not a valid mac addr: %s, err :%v", cfg.Mac, err)
+	// Without any network details, the unikernel runs without networking
+	var iface *Interface
+	if len(cfg.IPAddress) > 0 || len(cfg.Addresses) > 0 || len(cfg.Gateway) > 0 ||
+		len(cfg.Gateway6) > 0 || len(cfg.Tap) > 0 {
+		var err error
+		if iface, err = newInterface(cfg); err != nil {
+			return nil, err
 		}
-		mac = cfg.Mac
 	}
 
 	return &RunncCont{
 		NablaRunBin:  cfg.NablaRunBin,
 		NablaRunArgs: cfg.NablaRunArgs,
 		UniKernelBin: cfg.UniKernelBin,
-		Tap:          cfg.Tap,
-		IPAddress:    ipAddress,
-		IPMask:       ipMask,
-		Gateway:      gateway,
-		Mac:          mac,
+		Interface:    iface,
+		GuestRoutes:  cfg.GuestRoutes,
 		Memory:       cfg.Memory,
 		Disk:         cfg.Disk[0],
 		WorkingDir:   cfg.WorkingDir,
@@ -112,6 +118,64 @@ func NewRunncCont(cfg Config) (*RunncCont, error) {
 	}, nil
 }
 
+func newInterface(cfg Config) (*Interface, error) {
+	addresses := cfg.Addresses
+	if len(addresses) == 0 && len(cfg.IPAddress) > 0 {
+		addresses = []string{fmt.Sprintf("%s/%d", cfg.IPAddress, cfg.IPMask)}
+	}
+	if len(addresses) == 0 || len(cfg.Tap) == 0 {
+		return nil, fmt.Errorf("Insufficient network arguments set")
+	}
+	iface := &Interface{Tap: cfg.Tap}
+	for _, a := range addresses {
+		ip, ipNet, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("not a valid IP address: %s, err: %v", a, err)
+		}
+		iface.Addrs = append(iface.Addrs, &net.IPNet{IP: ip, Mask: ipNet.Mask})
+	}
+
+	var err error
+	if iface.Gateway, err = parseGateway(cfg.Gateway, false); err != nil {
+		return nil, err
+	}
+	if iface.Gateway6, err = parseGateway(cfg.Gateway6, true); err != nil {
+		return nil, err
+	}
+	for _, r := range cfg.Routes {
+		_, dst, err := net.ParseCIDR(r.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("not a valid route destination: %s, err: %v", r.Dst, err)
+		}
+		route := Route{Dst: dst}
+		if route.Gw, err = parseGateway(r.Gw, dst.IP.To4() == nil); err != nil {
+			return nil, err
+		}
+		iface.Routes = append(iface.Routes, route)
+	}
+
+	if len(cfg.Mac) > 0 {
+		if _, err := net.ParseMAC(cfg.Mac); err != nil {
+			return nil, fmt.Errorf("not a valid mac addr: %s, err :%v", cfg.Mac, err)
+		}
+		iface.Mac = cfg.Mac
+	}
+	return nil, nil
+}
+
+// parseGateway parses the IPv4, or IPv6 if v6, gateway s. An empty s is no
+// gateway.
+func parseGateway(s string, v6 bool) (net.IP, error) {
+	if s == "" {
+		return nil, nil
+	}
+	gateway := net.ParseIP(s)
+	if gateway == nil || (gateway.To4() == nil) != v6 {
+		return nil, fmt.Errorf("not a valid gateway address: %s", s)
+	}
+	return gateway, nil
+}
+
 func setupDisk(path string) (string, error) {
 	if path == "" {
 		return storage.CreateDummy()
@@ -133,11 +197,6 @@ func setupDisk(path string) (string, error) {
 }
 
 func (r *RunncCont) Run() error {
-	var (
-		mac string
-		err error
-	)
-
 	disk, err := setupDisk(r.Disk)
 	if err != nil {
 		return fmt.Errorf("could not setup the disk: %v", err)
@@ -153,31 +212,26 @@ func (r *RunncCont) Run() error {
 		r.UniKernelBin = unikernel
 	}
 
-	unikernelArgs, err := CreateRumprunArgs(r.IPAddress, r.IPMask, r.Gateway, "/",
+	unikernelArgs, err := CreateRumprunArgs(r.Interface, r.GuestRoutes, "/",
 		r.Env, r.WorkingDir, r.UniKernelBin, r.NablaRunArgs)
 	if err != nil {
 		return fmt.Errorf("could not create the unikernel cmdline: %v\n", err)
 	}
 
-	var args []string
-	if mac != "" {
-		args = []string{r.NablaRunBin,
-			"--x-exec-heap",
-			"--mem=" + strconv.FormatInt(r.Memory, 10),
-			"--net-mac=" + mac,
-			"--net=" + r.Tap,
-			"--disk=" + disk,
-			r.UniKernelBin,
-			unikernelArgs}
-	} else {
-		args = []string{r.NablaRunBin,
-			"--x-exec-heap",
-			"--mem=" + strconv.FormatInt(r.Memory, 10),
-			"--net=" + r.Tap,
-			"--disk=" + disk,
-			r.UniKernelBin,
-			unikernelArgs}
+	args := []string{r.NablaRunBin,
+		"--x-exec-heap",
+		"--mem=" + strconv.FormatInt(r.Memory, 10)}
+	// Without interface, the unikernel runs without networking
+	if iface := r.Interface; iface != nil {
+		if iface.Mac != "" {
+			args = append(args, "--net-mac="+iface.Mac)
+		}
+		args = append(args, "--net="+iface.Tap)
 	}
+	args = append(args, "--disk="+disk)
+	args = append(args,
+		r.UniKernelBin,
+		unikernelArgs)
 
 	fmt.Printf("nabla-run arg %s\n", args)
 
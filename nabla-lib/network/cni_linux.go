@@ -0,0 +1,167 @@
+// Copyright (c) 2018, IBM
+// Author(s): Brandon Lum, Ricardo Koller
+//
+// Permission to use, copy, modify, and/or distribute this software for
+// any purpose with or without fee is hereby granted, provided that the
+// above copyright notice and this permission notice appear in all
+// copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL
+// DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA
+// OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER
+// TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+// PERFORMANCE OF THIS SOFTWARE.
+
+// +build linux
+
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CNIConfList is a CNI network configuration list, see
+// https://github.com/containernetworking/cni/blob/master/SPEC.md
+type CNIConfList struct {
+	CNIVersion string                   `json:"cniVersion"`
+	Name       string                   `json:"name"`
+	Plugins    []map[string]interface{} `json:"plugins"`
+}
+
+// CNIRuntime is what the CNI plugins are invoked with: the directories to
+// find them in, and the container and interface to act on.
+type CNIRuntime struct {
+	Path        []string
+	ContainerID string
+	NetnsPath   string
+	IfName      string
+}
+
+// cniError is the error printed by a failed CNI plugin
+type cniError struct {
+	Code    uint   `json:"code"`
+	Msg     string `json:"msg"`
+	Details string `json:"details,omitempty"`
+}
+
+// ParseCNIConfList parses the CNI configuration list in b.
+func ParseCNIConfList(b []byte) (*CNIConfList, error) {
+	conf := &CNIConfList{}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, err
+	}
+	if conf.Name == "" {
+		return nil, fmt.Errorf("CNI configuration list has no name")
+	}
+	if len(conf.Plugins) == 0 {
+		return nil, fmt.Errorf("CNI configuration list %s has no plugins", conf.Name)
+	}
+	for _, p := range conf.Plugins {
+		if t, _ := p["type"].(string); t == "" {
+			return nil, fmt.Errorf("CNI configuration list %s has a plugin without type", conf.Name)
+		}
+	}
+	return conf, nil
+}
+
+// CNIAdd runs the ADD command of the plugins of conf in order, each one with
+// the result of the previous one, and returns the result of the last one. If
+// a plugin fails, the DEL command of the whole list is run, as required by
+// the CNI spec, so that the plugins added before it are released.
+func CNIAdd(conf *CNIConfList, rt *CNIRuntime) ([]byte, error) {
+	var result []byte
+	for _, p := range conf.Plugins {
+		out, err := execCNIPlugin("ADD", conf, p, rt, result)
+		if err != nil {
+			CNIDel(conf, rt, nil)
+			return nil, err
+		}
+		result = out
+	}
+	return result, nil
+}
+
+// CNIDel runs the DEL command of the plugins of conf in reverse order, with
+// the result of CNIAdd if there is one. All the plugins are run, so that
+// each releases what it can, and the first error is returned.
+func CNIDel(conf *CNIConfList, rt *CNIRuntime, result []byte) error {
+	var firstErr error
+	for i := len(conf.Plugins) - 1; i >= 0; i-- {
+		_, err := execCNIPlugin("DEL", conf, conf.Plugins[i], rt, result)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func execCNIPlugin(command string, conf *CNIConfList, plugin map[string]interface{},
+	rt *CNIRuntime, prevResult []byte) ([]byte, error) {
+
+	pluginType := plugin["type"].(string)
+	path, err := findCNIPlugin(pluginType, rt.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each plugin gets its own configuration, with the name and version of
+	// the list
+	netConf := map[string]interface{}{}
+	for k, v := range plugin {
+		netConf[k] = v
+	}
+	netConf["name"] = conf.Name
+	netConf["cniVersion"] = conf.CNIVersion
+	if len(prevResult) > 0 {
+		netConf["prevResult"] = json.RawMessage(prevResult)
+	}
+	stdin, err := json.Marshal(netConf)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"CNI_COMMAND="+command,
+		"CNI_CONTAINERID="+rt.ContainerID,
+		"CNI_NETNS="+rt.NetnsPath,
+		"CNI_IFNAME="+rt.IfName,
+		"CNI_ARGS=",
+		"CNI_PATH="+strings.Join(rt.Path, string(filepath.ListSeparator)),
+	)
+	if err := cmd.Run(); err != nil {
+		e := &cniError{}
+		if json.Unmarshal(stdout.Bytes(), e) == nil && e.Msg != "" {
+			return nil, fmt.Errorf("CNI plugin %s %s failed with code %d: %s %s",
+				pluginType, command, e.Code, e.Msg, e.Details)
+		}
+		return nil, fmt.Errorf("CNI plugin %s %s failed: %v: %s",
+			pluginType, command, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// findCNIPlugin returns the path of the plugin binary pluginType in the first
+// of dirs that has it.
+func findCNIPlugin(pluginType string, dirs []string) (string, error) {
+	for _, dir := range dirs {
+		path := filepath.Join(dir, pluginType)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("CNI plugin %s not found in %s", pluginType, strings.Join(dirs, ", "))
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2018, IBM
+// Author(s): Brandon Lum, Ricardo Koller
+//
+// SPDX-License-Identifier: ISC
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+// Permission to use, copy, modify, and/or distribute this software for
+// any purpose with or without fee is hereby granted, provided that the
+// above copyright notice and this permission notice appear in all
+// copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL
+// DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA
+// OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER
+// TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+// PERFORMANCE OF THIS SOFTWARE.
+
+// +build linux
+
+package network
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// MasterConfig is the configuration of a master link that
+// CreateTapInterfaceDocker and CreateMacvtapInterfaceDocker change, so that it
+// can be restored with RestoreMasterConfigAt once the container is gone.
+type MasterConfig struct {
+	Name   string        `json:"name"`
+	Mac    string        `json:"mac"`
+	Addrs  []string      `json:"addrs"`
+	Routes []MasterRoute `json:"routes"`
+}
+
+// MasterRoute is a route through a master link. An empty Dst is the default
+// route.
+type MasterRoute struct {
+	Dst      string `json:"dst,omitempty"`
+	Gw       string `json:"gw,omitempty"`
+	Src      string `json:"src,omitempty"`
+	Scope    uint8  `json:"scope"`
+	Priority int    `json:"priority,omitempty"`
+	Flags    int    `json:"flags,omitempty"`
+}
+
+// GetMasterConfig returns the addresses, MAC and routes of the link master.
+func GetMasterConfig(master string) (*MasterConfig, error) {
+	link, err := netlink.LinkByName(master)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &MasterConfig{
+		Name: master,
+		Mac:  link.Attrs().HardwareAddr.String(),
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		// The kernel gives the IPv6 link-local ones back by itself
+		if a.IP.To4() == nil && a.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		cfg.Addrs = append(cfg.Addrs, a.IPNet.String())
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		// The kernel sets the other flags, like linkdown, and refuses
+		// them when the route is added again
+		mr := MasterRoute{Scope: uint8(r.Scope), Priority: r.Priority, Flags: r.Flags & unix.RTNH_F_ONLINK}
+		if r.Dst != nil {
+			mr.Dst = r.Dst.String()
+		}
+		if r.Gw != nil {
+			mr.Gw = r.Gw.String()
+		}
+		if r.Src != nil {
+			mr.Src = r.Src.String()
+		}
+		cfg.Routes = append(cfg.Routes, mr)
+	}
+	return cfg, nil
+}
+
+// RestoreMasterConfigAt gives the master link of cfg back its MAC, addresses
+// and routes in the network namespace at nsPath, if both still exist. The
+// bridge or macvtap interface on top of the link must have been removed
+// first.
+func RestoreMasterConfigAt(nsPath string, cfg *MasterConfig) error {
+	return withNetnsHandle(nsPath, func(h *netlink.Handle) error {
+		link, err := h.LinkByName(cfg.Name)
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := h.LinkSetNoMaster(link); err != nil {
+			return err
+		}
+		mac, err := net.ParseMAC(cfg.Mac)
+		if err != nil {
+			return err
+		}
+		if err := h.LinkSetHardwareAddr(link, mac); err != nil {
+			return err
+		}
+		for _, a := range cfg.Addrs {
+			addr, err := netlink.ParseAddr(a)
+			if err != nil {
+				return err
+			}
+			if err := h.AddrAdd(link, addr); err != nil && err != unix.EEXIST {
+				return err
+			}
+		}
+		if err := h.LinkSetUp(link); err != nil {
+			return err
+		}
+
+		// The routes of the subnets come back with the addresses, the
+		// others, like the default route, are added again
+		for _, mr := range cfg.Routes {
+			r := &netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Scope:     netlink.Scope(mr.Scope),
+				Gw:        net.ParseIP(mr.Gw),
+				Src:       net.ParseIP(mr.Src),
+				Priority:  mr.Priority,
+				Flags:     mr.Flags,
+			}
+			if mr.Dst != "" {
+				if _, r.Dst, err = net.ParseCIDR(mr.Dst); err != nil {
+					return err
+				}
+			}
+			if err := h.RouteReplace(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -26,6 +26,8 @@
 package network
 
 import (
+	"bytes"
+	crand "crypto/rand"
 	"fmt"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
@@ -33,6 +35,7 @@ import (
 	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -111,8 +114,8 @@ func RemoveTapDevice(tapName string) error {
 
 // createMacvtapInterface creates a macvtap interface with the attributes taken
 // from a master link interface.
-// returns the macvtap, name of the tap device, dev path of the tap device and err
-func createMacvtapInterface(netHandle *netlink.Handle, masterLink netlink.Link) (*netlink.Macvtap, string, string, error) {
+// returns the macvtap, name of the tap device and err
+func createMacvtapInterface(netHandle *netlink.Handle, masterLink netlink.Link) (*netlink.Macvtap, string, error) {
 	masterLinkAttrs := masterLink.Attrs()
 
 	rand.Seed(time.Now().Unix())
@@ -139,162 +142,254 @@ func createMacvtapInterface(netHandle *netlink.Handle, masterLink netlink.Link)
 
 	err := netHandle.LinkAdd(macvtapLink)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("Couldn't add newlink: %v", err)
+		return nil, "", fmt.Errorf("Couldn't add newlink: %v", err)
 	}
 
-	return macvtapLink, name, fmt.Sprintf("/dev/tap%d", macvtapLink.Attrs().Index), nil
+	return macvtapLink, name, nil
 }
 
 // CreateMacvtapInterfaceDocker creates a Macvtap interface associated with
-// master (usually "eth0").  Returns the assigned IP/mask and gateway IP
-// (previously owned by master) and the MAC of the Macvtap interface that has
-// to be used by the unikernel's NIC.
+// master (usually "eth0"), and its char device at devPath.  Returns the IP
+// configuration previously owned by master, with the MAC of the Macvtap
+// interface that has to be used by the unikernel's NIC, and the name of the
+// Macvtap interface, which is removed again if the configuration fails.
 //
 // Got the idea of using macvtap's and the fix for the inability to get the
 // right index in a network namespace from the Kata containers repository:
 // https://github.com/kata-containers/runtime/blob/593bd44f207aa7b21e561184ca1b3fb79da47eb6/virtcontainers/network.go
 //
-func CreateMacvtapInterfaceDocker(master string) (
-	net.IP, net.IP, net.IPMask, string, string, error) {
+func CreateMacvtapInterfaceDocker(master string, devPath string) (
+	details *MasterDetails, name string, err error) {
 
 	netHandle, err := netlink.NewHandle()
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to create netlink handler")
+		return nil, "", errors.Wrap(err, "Unable to create netlink handler")
 	}
 
 	err = SetupTunDev()
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to setup tun dev")
+		return nil, "", errors.Wrap(err, "Unable to setup tun dev")
 	}
 
 	masterLink, err := netlink.LinkByName(master)
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "no master interface: %v")
+		return nil, "", errors.Wrap(err, "no master interface")
 	}
 
-	macvtapLink, name, newTapName, err := createMacvtapInterface(netHandle, masterLink)
+	macvtapLink, name, err := createMacvtapInterface(netHandle, masterLink)
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to create Macvtapint")
+		return nil, "", errors.Wrap(err, "Unable to create Macvtapint")
 	}
+	defer func() {
+		if err != nil {
+			netHandle.LinkDel(macvtapLink)
+		}
+	}()
 
-	addrs, err := netlink.AddrList(masterLink, netlink.FAMILY_V4)
-	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to get address list")
-	}
-	if len(addrs) == 0 {
-		return nil, nil, nil, "", "", fmt.Errorf("master should have an IP")
-	}
-	masterAddr := addrs[0]
-	masterIP := addrs[0].IPNet.IP
-	masterMask := addrs[0].IPNet.Mask
-
-	routes, err := netlink.RouteList(masterLink, netlink.FAMILY_V4)
+	details, masterAddrs, err := getMasterDetails(masterLink)
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to get route list")
+		return nil, "", err
 	}
-	if len(routes) == 0 {
-		return nil, nil, nil, "", "", fmt.Errorf("master should have at least one route")
-	}
-	// XXX: is the "gateway" always the first route?
-	gwAddr := routes[0].Gw
 
 	// ip addr del $INET_STR dev master
-	err = netlink.AddrDel(masterLink, &masterAddr)
-	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to delete address of master")
+	if err := delMasterAddrs(masterLink, masterAddrs); err != nil {
+		return nil, "", errors.Wrap(err, "Unable to delete address of master")
 	}
 
 	err = netlink.LinkSetUp(macvtapLink)
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to set up tap link")
+		return nil, "", errors.Wrap(err, "Unable to set up tap link")
 	}
 
 	err = netlink.LinkSetUp(masterLink)
 	if err != nil {
-		return nil, nil, nil, "", "", errors.Wrap(err, "Unable to set up master link")
+		return nil, "", errors.Wrap(err, "Unable to set up master link")
 	}
 
 	// The HardwareAddr Attr doesn't automatically get updated
 	_macvtapLink, err := netlink.LinkByName(name)
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return nil, "", err
 	}
-	tapMac := _macvtapLink.Attrs().HardwareAddr.String()
+	details.Mac = _macvtapLink.Attrs().HardwareAddr.String()
 
-	d := fmt.Sprintf("/sys/devices/virtual/net/%s/tap%d/dev",
-		name, macvtapLink.Attrs().Index)
-	b, err := ioutil.ReadFile(d)
+	major, minor, err := macvtapDevNumbers(name, macvtapLink.Attrs().Index)
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return nil, "", errors.Wrap(err, "Unable to get macvtap device numbers")
 	}
 
-	mm := strings.Split(string(b), ":")
-	major, err := strconv.Atoi(strings.TrimSpace(mm[0]))
+	// A stale node of a previous container
+	if err := os.Remove(devPath); err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	err = unix.Mknod(devPath, unix.S_IFCHR|0600, int(unix.Mkdev(major, minor)))
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return nil, "", err
 	}
 
-	minor, err := strconv.Atoi(strings.TrimSpace(mm[1]))
+	return details, name, nil
+}
+
+// macvtapDevNumbers returns the major and minor numbers of the char device of
+// the macvtap interface name, from sysfs. The sysfs mounted in /sys may
+// belong to another network namespace than the current one (i.e. in k8s), and
+// not have the interface. In that case a sysfs of the current network
+// namespace is mounted temporarily to read them.
+func macvtapDevNumbers(name string, index int) (uint32, uint32, error) {
+	rel := fmt.Sprintf("devices/virtual/net/%s/tap%d/dev", name, index)
+	b, err := ioutil.ReadFile(filepath.Join("/sys", rel))
+	if os.IsNotExist(err) {
+		b, err = readNetnsSysfs(rel)
+	}
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return 0, 0, err
 	}
 
-	err = unix.Mknod(newTapName, unix.S_IFCHR|0600,
-		int(unix.Mkdev(uint32(major), uint32(minor))))
+	mm := strings.Split(string(b), ":")
+	if len(mm) != 2 {
+		return 0, 0, fmt.Errorf("unexpected device numbers: %s", b)
+	}
+	major, err := strconv.ParseUint(strings.TrimSpace(mm[0]), 10, 32)
 	if err != nil {
-		return nil, nil, nil, "", "", err
+		return 0, 0, err
 	}
-
-	return masterIP, gwAddr, masterMask, tapMac, newTapName, nil
+	minor, err := strconv.ParseUint(strings.TrimSpace(mm[1]), 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(major), uint32(minor), nil
 }
 
-func getMasterDetails(masterLink netlink.Link) (masterAddr *netlink.Addr, masterIP net.IP, masterMask net.IPMask, gwAddr net.IP, mac string, err error) {
-	addrs, err := netlink.AddrList(masterLink, netlink.FAMILY_V4)
+// readNetnsSysfs reads the file rel of a sysfs mounted from the current
+// network namespace.
+func readNetnsSysfs(rel string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "runnc-sysfs")
 	if err != nil {
-		return nil, nil, nil, nil, "", err
+		return nil, err
 	}
-	if len(addrs) == 0 {
-		return nil, nil, nil, nil, "", fmt.Errorf("master should have an IP")
+	defer os.Remove(dir)
+
+	if err := unix.Mount("sysfs", dir, "sysfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return nil, errors.Wrap(err, "Unable to mount sysfs")
 	}
-	masterAddr = &addrs[0]
-	masterIP = addrs[0].IPNet.IP
-	masterMask = addrs[0].IPNet.Mask
+	defer unix.Unmount(dir, unix.MNT_DETACH)
 
-	routes, err := netlink.RouteList(masterLink, netlink.FAMILY_V4)
-	if err != nil {
-		return nil, nil, nil, nil, "", err
+	return ioutil.ReadFile(filepath.Join(dir, rel))
+}
+
+// MasterDetails is the IP configuration of a master link, which is handed
+// over to the unikernel.
+type MasterDetails struct {
+	// Addrs are the IPv4 and IPv6 addresses, IPv4 first, without the
+	// IPv6 link-local ones
+	Addrs []*net.IPNet
+
+	// Gateway and Gateway6 are the IPv4 and IPv6 default gateways, nil if
+	// there is none
+	Gateway  net.IP
+	Gateway6 net.IP
+
+	// Routes are the other routes through the link, without the ones the
+	// kernel adds for the subnets of the addresses
+	Routes []Route
+
+	// Mac is the MAC address the unikernel uses
+	Mac string
+}
+
+// Route is a route through a link. A route without gateway is a device
+// route.
+type Route struct {
+	Dst *net.IPNet
+	Gw  net.IP
+}
+
+func getMasterDetails(masterLink netlink.Link) (*MasterDetails, []netlink.Addr, error) {
+	details := &MasterDetails{
+		Mac: masterLink.Attrs().HardwareAddr.String(),
 	}
-	if len(routes) == 0 {
-		return nil, nil, nil, nil, "",
-			fmt.Errorf("master should have at least one route")
+
+	var masterAddrs []netlink.Addr
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		addrs, err := netlink.AddrList(masterLink, family)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range addrs {
+			if a.IP.To4() == nil && a.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			masterAddrs = append(masterAddrs, a)
+			details.Addrs = append(details.Addrs, a.IPNet)
+		}
+
+		routes, err := netlink.RouteList(masterLink, family)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, r := range routes {
+			switch {
+			case r.Protocol == unix.RTPROT_KERNEL || isAddrSubnet(details.Addrs, r.Dst):
+			case r.Dst != nil:
+				details.Routes = append(details.Routes, Route{Dst: r.Dst, Gw: r.Gw})
+			case r.Gw == nil:
+			case family == netlink.FAMILY_V4 && details.Gateway == nil:
+				details.Gateway = r.Gw
+			case family == netlink.FAMILY_V6 && details.Gateway6 == nil:
+				details.Gateway6 = r.Gw
+			}
+		}
 	}
-	// XXX: is the "gateway" always the first route?
-	gwAddr = routes[0].Gw
+	if len(details.Addrs) == 0 {
+		return nil, nil, fmt.Errorf("master should have an IP")
+	}
+	return details, masterAddrs, nil
+}
 
-	macAddr := masterLink.Attrs().HardwareAddr.String()
-	return masterAddr, masterIP, masterMask, gwAddr, macAddr, nil
+// isAddrSubnet returns whether dst is the subnet of one of addrs
+func isAddrSubnet(addrs []*net.IPNet, dst *net.IPNet) bool {
+	if dst == nil {
+		return false
+	}
+	for _, a := range addrs {
+		if a.IP.Mask(a.Mask).Equal(dst.IP) && bytes.Equal(a.Mask, dst.Mask) {
+			return true
+		}
+	}
+	return false
 }
 
-// CreateTapInterfaceDocker creates a new TAP interface and a bridge, adds both
-// the TAP and the master link (usually eth0) to the bridge, and unsets the IP
-// of the master link to be used by the unikernel NIC.  Returns the assigned
-// IP/mask and gateway IP.
-func CreateTapInterfaceDocker(tapName string, master string) (
-	net.IP, net.IP, net.IPMask, string, error) {
+// delMasterAddrs removes the addresses taken over by the unikernel from the
+// master link
+func delMasterAddrs(masterLink netlink.Link, addrs []netlink.Addr) error {
+	for i := range addrs {
+		if err := netlink.AddrDel(masterLink, &addrs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTapInterfaceDocker creates a new TAP interface and a bridge named
+// bridgeName, adds both the TAP and the master link (usually eth0) to the
+// bridge, and unsets the IPs and MAC of the master link to be used by the
+// unikernel NIC. The master link gets masterMac instead, or a random MAC if
+// nil.  Returns the IP configuration of the master, with its original MAC.
+func CreateTapInterfaceDocker(tapName string, master string, bridgeName string,
+	masterMac net.HardwareAddr) (*MasterDetails, error) {
 
 	masterLink, err := netlink.LinkByName(master)
 	if err != nil {
-		return nil, nil, nil, "",
-			fmt.Errorf("no master interface: %v", err)
+		return nil, fmt.Errorf("no master interface: %v", err)
 	}
-	masterAddr, masterIP, masterMask, gwAddr, mac, err := getMasterDetails(masterLink)
+	details, masterAddrs, err := getMasterDetails(masterLink)
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
 
 	err = SetupTunDev()
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
 
 	// ip tuntap add tap100 mode tap
@@ -303,34 +398,34 @@ func CreateTapInterfaceDocker(tapName string, master string) (
 		Mode:      netlink.TUNTAP_MODE_TAP}
 	err = netlink.LinkAdd(tap)
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
 
 	// ip link set dev tap100 up'
 	err = netlink.LinkSetUp(tap)
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
 
 	// ip addr del $INET_STR dev master
-	err = netlink.AddrDel(masterLink, masterAddr)
-	if err != nil {
-		return nil, nil, nil, "", err
+	if err := delMasterAddrs(masterLink, masterAddrs); err != nil {
+		return nil, err
 	}
 
-	genmac, err := net.ParseMAC("aa:aa:aa:aa:bb:cc")
-	if err != nil {
-		return nil, nil, nil, "", err
+	if masterMac == nil {
+		if masterMac, err = RandomMac(); err != nil {
+			return nil, err
+		}
 	}
 
-	err = netlink.LinkSetHardwareAddr(masterLink, genmac)
+	err = netlink.LinkSetHardwareAddr(masterLink, masterMac)
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
 
-	br0, err := CreateBridge("br0")
+	br0, err := CreateBridge(bridgeName)
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
 
 	netlink.LinkSetMaster(masterLink, br0)
@@ -339,9 +434,33 @@ func CreateTapInterfaceDocker(tapName string, master string) (
 	// ip link set dev br0 up'
 	err = netlink.LinkSetUp(br0)
 	if err != nil {
-		return nil, nil, nil, "", err
+		return nil, err
 	}
-	return masterIP, gwAddr, masterMask, mac, nil
+	return details, nil
+}
+
+// RandomMac returns a random unicast and locally administered MAC.
+func RandomMac() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := crand.Read(mac); err != nil {
+		return nil, err
+	}
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac, nil
+}
+
+// RemoveLinkAt removes the link name, i.e. a bridge or a TAP, in the network
+// namespace at nsPath, if both still exist.
+func RemoveLinkAt(nsPath string, name string) error {
+	return withNetnsHandle(nsPath, func(h *netlink.Handle) error {
+		link, err := h.LinkByName(name)
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return h.LinkDel(link)
+	})
 }
 
 // SetupTunDev sets up the /dev/net/tun device if it doesn't exists
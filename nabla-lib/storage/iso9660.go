@@ -0,0 +1,922 @@
+// Copyright (c) 2018, IBM
+// Author(s): Brandon Lum, Ricardo Koller
+//
+// Permission to use, copy, modify, and/or distribute this software for
+// any purpose with or without fee is hereby granted, provided that the
+// above copyright notice and this permission notice appear in all
+// copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL
+// DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA
+// OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER
+// TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+// PERFORMANCE OF THIS SOFTWARE.
+
+// +build linux
+
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// This is a writer of ISO9660 images with the Rock Ridge (RRIP 1.09)
+// extensions, which produces the same file tree as:
+//
+//	genisoimage -m dev -m sys -m proc -l -r
+//
+// That is, files named dev, sys or proc are excluded at any depth, ISO9660
+// names have up to 31 characters, and the Rock Ridge attributes are
+// rationalized: uid and gid are 0, all read bits are set, the execute bits
+// are set for everybody if set for anybody, and the write and special bits
+// are cleared. Deep directories are not relocated, since neither Linux nor
+// NetBSD need it.
+//
+// The image layout is: the system area, the primary volume descriptor at
+// sector 16, the terminator, the L and M path tables, the directories, the
+// continuation areas of the Rock Ridge entries, and the file data, where the
+// slots take IsoSlotSize bytes.
+
+const (
+	sectorSize = 2048
+
+	// isoMaxFileSize is the largest file a single extent can hold
+	isoMaxFileSize = 1<<32 - 1
+
+	// ceLen is the length of a CE (continuation area) entry
+	ceLen = 28
+
+	// maxRecordLen is the maximum (even) length of a directory record
+	maxRecordLen = 254
+)
+
+// isoExcludes are the genisoimage -m patterns.
+var isoExcludes = []string{"dev", "sys", "proc"}
+
+// Rock Ridge flags of the RR entry
+const (
+	rrPX = 1 << 0
+	rrPN = 1 << 1
+	rrSL = 1 << 2
+	rrNM = 1 << 3
+	rrTF = 1 << 7
+)
+
+// Component flags of the SL entry
+const (
+	slContinue = 1 << 0
+	slCurrent  = 1 << 1
+	slParent   = 1 << 2
+	slRoot     = 1 << 3
+)
+
+const (
+	erID         = "RRIP_1991A"
+	erDescriptor = "THE ROCK RIDGE INTERCHANGE PROTOCOL PROVIDES SUPPORT FOR POSIX FILE SYSTEM SEMANTICS"
+	erSource     = "PLEASE CONTACT DISC PUBLISHER FOR SPECIFICATION SOURCE.  SEE PUBLISHER IDENTIFIER IN PRIMARY VOLUME DESCRIPTOR FOR CONTACT INFORMATION."
+)
+
+type isoNode struct {
+	// name is the Rock Ridge name, and base and ext the ISO9660 one
+	name      string
+	base, ext string
+	path      string
+	stat      *syscall.Stat_t
+	target    string
+	parent    *isoNode
+	children  []*isoNode
+
+	// link is the first node of the hard links to the same file, which
+	// holds the data
+	link  *isoNode
+	nlink uint32
+
+	dirNum  int
+	extent  uint32
+	size    uint32
+	records []*isoRecord
+
+	// slot is set for the empty files with room for IsoSlotSize bytes, and
+	// recordPos is then the offset of their directory record
+	slot      bool
+	recordPos int64
+}
+
+func (n *isoNode) isDir() bool {
+	return n.stat.Mode&syscall.S_IFMT == syscall.S_IFDIR
+}
+
+func (n *isoNode) isRegular() bool {
+	return n.stat.Mode&syscall.S_IFMT == syscall.S_IFREG
+}
+
+// identifier returns the ISO9660 file identifier of the node.
+func (n *isoNode) identifier() string {
+	if n.isDir() {
+		return n.base
+	}
+	return n.base + "." + n.ext + ";1"
+}
+
+// isoRecord is a directory record, with its system use area split in the
+// part in the record and the continuation areas.
+type isoRecord struct {
+	node   *isoNode
+	ident  []byte
+	areas  [][]byte
+	ces    []cePos
+	length int
+}
+
+// cePos is the location of a continuation area.
+type cePos struct {
+	lba    uint32
+	offset uint32
+	length uint32
+}
+
+type isoWriter struct {
+	root   *isoNode
+	dirs   []*isoNode
+	files  []*isoNode
+	inodes map[[2]uint64]*isoNode
+
+	// slotPaths are the host paths of the slots, and slots their nodes
+	slotPaths map[string]bool
+	slots     []*isoNode
+
+	pathTableLen     uint32
+	lPathTable       uint32
+	mPathTable       uint32
+	pathTableSectors uint32
+	ceStart          uint32
+	ceSectors        uint32
+	volumeSectors    uint32
+	created          time.Time
+}
+
+// writeISO writes the ISO image of dir to fname, with the paths slots,
+// relative to dir, as empty slots, and returns the slots.
+func writeISO(dir, fname string, slots []string) ([]IsoSlot, error) {
+	w := &isoWriter{
+		inodes:    map[[2]uint64]*isoNode{},
+		slotPaths: map[string]bool{},
+		created:   time.Now().UTC(),
+	}
+	for _, p := range slots {
+		w.slotPaths[filepath.Join(dir, p)] = true
+	}
+	root, err := w.walk(dir, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(w.slots) != len(w.slotPaths) {
+		return nil, fmt.Errorf("the directories of the slots %v are not all in %s", slots, dir)
+	}
+	w.root = root
+	w.layout()
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriterSize(f, 1<<20)
+	if err := w.write(bw); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	var ret []IsoSlot
+	for _, n := range w.slots {
+		rel, err := filepath.Rel(dir, n.path)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, IsoSlot{
+			Path:   filepath.Join("/", rel),
+			Record: n.recordPos,
+			Data:   int64(n.extent) * sectorSize,
+		})
+	}
+	return ret, nil
+}
+
+func isoExcluded(name string) bool {
+	for _, pattern := range isoExcludes {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *isoWriter) walk(path, name string, parent *isoNode) (*isoNode, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	n := &isoNode{
+		name:   name,
+		path:   path,
+		stat:   fi.Sys().(*syscall.Stat_t),
+		parent: parent,
+		nlink:  1,
+	}
+
+	switch {
+	case n.isDir():
+		names, err := readDirNames(path)
+		if err != nil {
+			return nil, err
+		}
+		names = w.addSlotNames(path, names)
+		n.nlink = 2
+		for _, c := range names {
+			if isoExcluded(c) {
+				continue
+			}
+			var child *isoNode
+			if cpath := filepath.Join(path, c); w.slotPaths[cpath] {
+				child = w.slotNode(cpath, c, n)
+			} else if child, err = w.walk(cpath, c, n); err != nil {
+				return nil, err
+			}
+			if child.isDir() {
+				n.nlink++
+			}
+			n.children = append(n.children, child)
+		}
+		assignISONames(n.children)
+	case fi.Mode()&os.ModeSymlink != 0:
+		if n.target, err = os.Readlink(path); err != nil {
+			return nil, err
+		}
+	case n.isRegular():
+		if fi.Size() > isoMaxFileSize {
+			return nil, fmt.Errorf("%s is too large for an ISO9660 image", path)
+		}
+		n.size = uint32(fi.Size())
+		if n.stat.Nlink > 1 {
+			key := [2]uint64{uint64(n.stat.Dev), n.stat.Ino}
+			if first, ok := w.inodes[key]; ok {
+				n.link = first
+				first.nlink++
+				break
+			}
+			w.inodes[key] = n
+		}
+		w.files = append(w.files, n)
+	}
+	return n, nil
+}
+
+// addSlotNames adds the names of the slots of the directory path that are
+// not in names.
+func (w *isoWriter) addSlotNames(path string, names []string) []string {
+	added := false
+	for p := range w.slotPaths {
+		if filepath.Dir(p) != path {
+			continue
+		}
+		i := sort.SearchStrings(names, filepath.Base(p))
+		if i < len(names) && names[i] == filepath.Base(p) {
+			continue
+		}
+		names = append(names, filepath.Base(p))
+		added = true
+	}
+	if added {
+		sort.Strings(names)
+	}
+	return names
+}
+
+// slotNode returns the node of the slot at path, an empty file owned by root
+// with mode 0644 and the time of the image.
+func (w *isoWriter) slotNode(path, name string, parent *isoNode) *isoNode {
+	ts := syscall.NsecToTimespec(w.created.UnixNano())
+	n := &isoNode{
+		name: name,
+		path: path,
+		stat: &syscall.Stat_t{
+			Mode:  syscall.S_IFREG | 0644,
+			Nlink: 1,
+			Atim:  ts,
+			Mtim:  ts,
+			Ctim:  ts,
+		},
+		parent: parent,
+		nlink:  1,
+		slot:   true,
+	}
+	w.files = append(w.files, n)
+	w.slots = append(w.slots, n)
+	return n
+}
+
+func readDirNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// assignISONames gives unique ISO9660 names to the children of a directory,
+// and sorts them in the ISO9660 order.
+func assignISONames(children []*isoNode) {
+	used := map[string]bool{}
+	for _, n := range children {
+		base, ext := isoName(n.name, n.isDir())
+		// Directories have up to 31 characters, files up to 30 plus the dot
+		max := 31
+		if !n.isDir() {
+			max = 30 - len(ext)
+		}
+		if len(base) > max {
+			base = base[:max]
+		}
+		b := base
+		for i := 0; used[b+"."+ext]; i++ {
+			suffix := fmt.Sprintf("%03d", i)
+			if len(base)+len(suffix) > max {
+				b = base[:max-len(suffix)] + suffix
+			} else {
+				b = base + suffix
+			}
+		}
+		used[b+"."+ext] = true
+		n.base, n.ext = b, ext
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].base != children[j].base {
+			return children[i].base < children[j].base
+		}
+		return children[i].ext < children[j].ext
+	})
+}
+
+// isoName maps name to upper case d-characters, with a single dot before
+// the extension of files.
+func isoName(name string, isDir bool) (base, ext string) {
+	mangle := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				return r
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			}
+			return '_'
+		}, s)
+	}
+	dot := -1
+	if !isDir {
+		dot = strings.LastIndex(name, ".")
+	}
+	if dot <= 0 {
+		return mangle(name), ""
+	}
+	ext = mangle(name[dot+1:])
+	if len(ext) > 30 {
+		ext = ext[:30]
+	}
+	return mangle(name[:dot]), ext
+}
+
+// layout assigns the location of every directory, continuation area and
+// file of the image.
+func (w *isoWriter) layout() {
+	// Directories are numbered breadth first for the path tables
+	w.dirs = []*isoNode{w.root}
+	for i := 0; i < len(w.dirs); i++ {
+		w.dirs[i].dirNum = i + 1
+		for _, c := range w.dirs[i].children {
+			if c.isDir() {
+				w.dirs = append(w.dirs, c)
+			}
+		}
+	}
+	for _, d := range w.dirs {
+		l := len(d.identifier())
+		if d == w.root {
+			l = 1
+		}
+		w.pathTableLen += uint32(8 + l + l%2)
+	}
+	w.pathTableSectors = sectors(w.pathTableLen)
+	w.lPathTable = 18
+	w.mPathTable = w.lPathTable + w.pathTableSectors
+
+	lba := w.mPathTable + w.pathTableSectors
+	for _, d := range w.dirs {
+		d.records = w.dirRecords(d)
+		d.extent = lba
+		var size uint32
+		for _, r := range d.records {
+			// Records do not cross sector boundaries
+			if size%sectorSize+uint32(r.length) > sectorSize {
+				size += sectorSize - size%sectorSize
+			}
+			size += uint32(r.length)
+		}
+		d.size = sectors(size) * sectorSize
+		lba += sectors(size)
+	}
+
+	// Continuation areas do not cross sector boundaries either
+	w.ceStart = lba
+	var ceSize uint32
+	for _, d := range w.dirs {
+		for _, r := range d.records {
+			for _, area := range r.areas[1:] {
+				length := uint32(len(area))
+				if len(r.ces) < len(r.areas)-2 {
+					length += ceLen
+				}
+				if ceSize%sectorSize+length > sectorSize {
+					ceSize += sectorSize - ceSize%sectorSize
+				}
+				r.ces = append(r.ces, cePos{
+					lba:    w.ceStart + ceSize/sectorSize,
+					offset: ceSize % sectorSize,
+					length: length,
+				})
+				ceSize += length
+			}
+		}
+	}
+	w.ceSectors = sectors(ceSize)
+	lba += w.ceSectors
+
+	for _, f := range w.files {
+		size := f.size
+		if f.slot {
+			size = IsoSlotSize
+		}
+		if size == 0 {
+			continue
+		}
+		f.extent = lba
+		lba += sectors(size)
+	}
+	w.volumeSectors = lba
+}
+
+func sectors(size uint32) uint32 {
+	return (size + sectorSize - 1) / sectorSize
+}
+
+// dirRecords returns the records of the directory d: ".", ".." and one for
+// each child.
+func (w *isoWriter) dirRecords(d *isoNode) []*isoRecord {
+	parent := d.parent
+	if parent == nil {
+		parent = d
+	}
+
+	self := w.systemUse(d, true)
+	if d == w.root {
+		sp := suEntry("SP", 1, []byte{0xbe, 0xef, 0})
+		self = append([][]byte{sp}, self...)
+		self = append(self, erEntry())
+	}
+	records := []*isoRecord{
+		newISORecord(d, []byte{0}, self),
+		newISORecord(parent, []byte{1}, w.systemUse(parent, true)),
+	}
+	for _, c := range d.children {
+		records = append(records, newISORecord(c, []byte(c.identifier()), w.systemUse(c, false)))
+	}
+	return records
+}
+
+func newISORecord(n *isoNode, ident []byte, entries [][]byte) *isoRecord {
+	header := 33 + len(ident)
+	if len(ident)%2 == 0 {
+		header++
+	}
+	r := &isoRecord{
+		node:  n,
+		ident: ident,
+		areas: splitSystemUse(entries, maxRecordLen-header),
+	}
+	r.length = header + len(r.areas[0])
+	if len(r.areas) > 1 {
+		r.length += ceLen
+	}
+	r.length += r.length % 2
+	return r
+}
+
+// splitSystemUse splits the system use entries between the record, which
+// has room for inlineCap bytes, and as many continuation areas as needed.
+func splitSystemUse(entries [][]byte, inlineCap int) [][]byte {
+	var areas [][]byte
+	var cur []byte
+	capacity := inlineCap
+	for i := 0; ; {
+		rest := 0
+		for _, e := range entries[i:] {
+			rest += len(e)
+		}
+		if len(cur)+rest <= capacity {
+			for _, e := range entries[i:] {
+				cur = append(cur, e...)
+			}
+			return append(areas, cur)
+		}
+		// Keep room for the CE entry to the next area
+		for i < len(entries) && len(cur)+len(entries[i]) <= capacity-ceLen {
+			cur = append(cur, entries[i]...)
+			i++
+		}
+		areas = append(areas, cur)
+		cur = nil
+		capacity = sectorSize
+	}
+}
+
+// systemUse returns the Rock Ridge entries of the node n, without its name
+// for the "." and ".." records.
+func (w *isoWriter) systemUse(n *isoNode, dot bool) [][]byte {
+	var flags byte = rrPX | rrTF
+	var entries [][]byte
+
+	nlink := n.nlink
+	if n.link != nil {
+		nlink = n.link.nlink
+	}
+	px := make([]byte, 32)
+	both32(px[0:], rationalizeMode(n.stat.Mode))
+	both32(px[8:], nlink)
+	entries = append(entries, suEntry("PX", 1, px))
+
+	if typ := n.stat.Mode & syscall.S_IFMT; typ == syscall.S_IFCHR || typ == syscall.S_IFBLK {
+		flags |= rrPN
+		pn := make([]byte, 16)
+		both32(pn[0:], unix.Major(uint64(n.stat.Rdev)))
+		both32(pn[8:], unix.Minor(uint64(n.stat.Rdev)))
+		entries = append(entries, suEntry("PN", 1, pn))
+	}
+
+	// Modification, access and attribute change times
+	tf := []byte{0x0e}
+	for _, ts := range []syscall.Timespec{n.stat.Mtim, n.stat.Atim, n.stat.Ctim} {
+		tf = append(tf, isoTime(time.Unix(ts.Unix()))...)
+	}
+	entries = append(entries, suEntry("TF", 1, tf))
+
+	if !dot {
+		flags |= rrNM
+		entries = append(entries, nmEntries(n.name)...)
+		if n.stat.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+			flags |= rrSL
+			entries = append(entries, slEntries(n.target)...)
+		}
+	}
+
+	rr := suEntry("RR", 1, []byte{flags})
+	return append([][]byte{rr}, entries...)
+}
+
+// rationalizeMode implements the genisoimage -r rules for the file modes.
+func rationalizeMode(mode uint32) uint32 {
+	typ := mode & syscall.S_IFMT
+	perm := mode & 0777
+	if typ == syscall.S_IFDIR {
+		return typ | 0555
+	}
+	perm |= 0444
+	if perm&0111 != 0 {
+		perm |= 0111
+	}
+	perm &^= 0222
+	return typ | perm
+}
+
+func suEntry(sig string, version byte, data []byte) []byte {
+	e := make([]byte, 4, 4+len(data))
+	e[0], e[1] = sig[0], sig[1]
+	e[2] = byte(4 + len(data))
+	e[3] = version
+	return append(e, data...)
+}
+
+func erEntry() []byte {
+	data := []byte{byte(len(erID)), byte(len(erDescriptor)), byte(len(erSource)), 1}
+	data = append(data, erID...)
+	data = append(data, erDescriptor...)
+	data = append(data, erSource...)
+	return suEntry("ER", 1, data)
+}
+
+func ceEntry(pos cePos) []byte {
+	data := make([]byte, 24)
+	both32(data[0:], pos.lba)
+	both32(data[8:], pos.offset)
+	both32(data[16:], pos.length)
+	return suEntry("CE", 1, data)
+}
+
+// nmEntries returns the NM entries of name, which is split in several
+// entries if needed.
+func nmEntries(name string) [][]byte {
+	var entries [][]byte
+	for {
+		chunk := name
+		var flags byte
+		if len(chunk) > 250 {
+			chunk = name[:250]
+			flags = 1
+		}
+		entries = append(entries, suEntry("NM", 1, append([]byte{flags}, chunk...)))
+		name = name[len(chunk):]
+		if name == "" {
+			return entries
+		}
+	}
+}
+
+// slEntries returns the SL entries of a symbolic link to target.
+func slEntries(target string) [][]byte {
+	var comps [][]byte
+	if strings.HasPrefix(target, "/") {
+		comps = append(comps, []byte{slRoot, 0})
+	}
+	for _, c := range strings.Split(target, "/") {
+		switch c {
+		case "":
+		case ".":
+			comps = append(comps, []byte{slCurrent, 0})
+		case "..":
+			comps = append(comps, []byte{slParent, 0})
+		default:
+			for len(c) > 248 {
+				comps = append(comps, append([]byte{slContinue, 248}, c[:248]...))
+				c = c[248:]
+			}
+			comps = append(comps, append([]byte{0, byte(len(c))}, c...))
+		}
+	}
+
+	var bodies [][]byte
+	var cur []byte
+	for _, comp := range comps {
+		if 5+len(cur)+len(comp) > 255 {
+			bodies = append(bodies, cur)
+			cur = nil
+		}
+		cur = append(cur, comp...)
+	}
+	bodies = append(bodies, cur)
+
+	entries := make([][]byte, len(bodies))
+	for i, body := range bodies {
+		var flags byte
+		if i < len(bodies)-1 {
+			flags = 1
+		}
+		entries[i] = suEntry("SL", 1, append([]byte{flags}, body...))
+	}
+	return entries
+}
+
+// isoTime returns the 7 byte recording date and time of a directory record.
+func isoTime(t time.Time) []byte {
+	t = t.UTC()
+	year := t.Year() - 1900
+	if year < 0 {
+		year = 0
+	} else if year > 255 {
+		year = 255
+	}
+	return []byte{byte(year), byte(t.Month()), byte(t.Day()),
+		byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0}
+}
+
+// isoDecTime returns the 17 byte date and time of a volume descriptor.
+func isoDecTime(t time.Time) []byte {
+	t = t.UTC()
+	s := fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/10000000)
+	return append([]byte(s), 0)
+}
+
+func both16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func both32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// encodeRecord returns the directory record r, with the CE entry to its
+// first continuation area.
+func encodeRecord(r *isoRecord) []byte {
+	b := make([]byte, 33, r.length)
+	n := r.node
+	extent, size := n.extent, n.size
+	if n.link != nil {
+		extent, size = n.link.extent, n.link.size
+	}
+	b[0] = byte(r.length)
+	both32(b[2:], extent)
+	both32(b[10:], size)
+	copy(b[18:25], isoTime(time.Unix(n.stat.Mtim.Unix())))
+	if n.isDir() {
+		b[25] = 2
+	}
+	both16(b[28:], 1)
+	b[32] = byte(len(r.ident))
+	b = append(b, r.ident...)
+	if len(r.ident)%2 == 0 {
+		b = append(b, 0)
+	}
+	b = append(b, r.areas[0]...)
+	if len(r.areas) > 1 {
+		b = append(b, ceEntry(r.ces[0])...)
+	}
+	for len(b) < r.length {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func padString(s string, n int) []byte {
+	b := []byte(s)
+	for len(b) < n {
+		b = append(b, ' ')
+	}
+	return b[:n]
+}
+
+func (w *isoWriter) primaryVolumeDescriptor() []byte {
+	b := make([]byte, sectorSize)
+	b[0] = 1
+	copy(b[1:6], "CD001")
+	b[6] = 1
+	copy(b[8:40], padString("LINUX", 32))
+	copy(b[40:72], padString("CDROM", 32))
+	both32(b[80:], w.volumeSectors)
+	both16(b[120:], 1)
+	both16(b[124:], 1)
+	both16(b[128:], sectorSize)
+	both32(b[132:], w.pathTableLen)
+	binary.LittleEndian.PutUint32(b[140:], w.lPathTable)
+	binary.BigEndian.PutUint32(b[148:], w.mPathTable)
+
+	root := &isoRecord{node: w.root, ident: []byte{0}, areas: [][]byte{nil}, length: 34}
+	copy(b[156:190], encodeRecord(root))
+
+	copy(b[190:318], padString("", 128))
+	copy(b[318:446], padString("", 128))
+	copy(b[446:574], padString("", 128))
+	copy(b[574:702], padString("", 128))
+	copy(b[702:813], padString("", 111))
+	copy(b[813:830], isoDecTime(w.created))
+	copy(b[830:847], isoDecTime(w.created))
+	copy(b[847:864], append([]byte("0000000000000000"), 0))
+	copy(b[864:881], append([]byte("0000000000000000"), 0))
+	b[881] = 1
+	return b
+}
+
+func (w *isoWriter) pathTable(order binary.ByteOrder) []byte {
+	b := make([]byte, 0, w.pathTableSectors*sectorSize)
+	for _, d := range w.dirs {
+		ident := []byte(d.identifier())
+		parent := 1
+		if d == w.root {
+			ident = []byte{0}
+		} else {
+			parent = d.parent.dirNum
+		}
+		e := make([]byte, 8)
+		e[0] = byte(len(ident))
+		order.PutUint32(e[2:], d.extent)
+		order.PutUint16(e[6:], uint16(parent))
+		e = append(e, ident...)
+		if len(ident)%2 == 1 {
+			e = append(e, 0)
+		}
+		b = append(b, e...)
+	}
+	return b[:cap(b)]
+}
+
+func (w *isoWriter) write(out io.Writer) error {
+	if len(w.dirs) > 0xffff {
+		return fmt.Errorf("too many directories for an ISO9660 image")
+	}
+
+	if _, err := out.Write(make([]byte, 16*sectorSize)); err != nil {
+		return err
+	}
+	if _, err := out.Write(w.primaryVolumeDescriptor()); err != nil {
+		return err
+	}
+	term := make([]byte, sectorSize)
+	term[0] = 255
+	copy(term[1:6], "CD001")
+	term[6] = 1
+	if _, err := out.Write(term); err != nil {
+		return err
+	}
+	if _, err := out.Write(w.pathTable(binary.LittleEndian)); err != nil {
+		return err
+	}
+	if _, err := out.Write(w.pathTable(binary.BigEndian)); err != nil {
+		return err
+	}
+
+	ce := make([]byte, w.ceSectors*sectorSize)
+	for _, d := range w.dirs {
+		buf := make([]byte, 0, d.size)
+		for _, r := range d.records {
+			if len(buf)%sectorSize+r.length > sectorSize {
+				buf = append(buf, make([]byte, sectorSize-len(buf)%sectorSize)...)
+			}
+			if r.node.slot {
+				r.node.recordPos = int64(d.extent)*sectorSize + int64(len(buf))
+			}
+			buf = append(buf, encodeRecord(r)...)
+
+			for i, area := range r.areas[1:] {
+				pos := r.ces[i]
+				off := (pos.lba-w.ceStart)*sectorSize + pos.offset
+				n := copy(ce[off:], area)
+				if i+1 < len(r.ces) {
+					copy(ce[off+uint32(n):], ceEntry(r.ces[i+1]))
+				}
+			}
+		}
+		if _, err := out.Write(buf[:cap(buf)]); err != nil {
+			return err
+		}
+	}
+	if _, err := out.Write(ce); err != nil {
+		return err
+	}
+
+	for _, f := range w.files {
+		if f.slot {
+			if _, err := out.Write(make([]byte, sectors(IsoSlotSize)*sectorSize)); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.size == 0 {
+			continue
+		}
+		if err := copyFileData(out, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileData writes the data of the file f padded to a whole sector.
+func copyFileData(out io.Writer, f *isoNode) error {
+	in, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := io.CopyN(out, in, int64(f.size)); err != nil {
+		return fmt.Errorf("copying %s: %v", f.path, err)
+	}
+	if pad := sectors(f.size)*sectorSize - f.size; pad > 0 {
+		_, err = out.Write(make([]byte, pad))
+	}
+	return err
+}
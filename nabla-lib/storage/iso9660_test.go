@@ -0,0 +1,402 @@
+// Copyright (c) 2018, IBM
+// Author(s): Brandon Lum, Ricardo Koller
+//
+// Permission to use, copy, modify, and/or distribute this software for
+// any purpose with or without fee is hereby granted, provided that the
+// above copyright notice and this permission notice appear in all
+// copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL
+// DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA
+// OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER
+// TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+// PERFORMANCE OF THIS SOFTWARE.
+
+// +build linux
+
+package storage
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// isoFile is a file read back from an image.
+type isoFile struct {
+	mode   uint32
+	target string
+	data   string
+}
+
+// isoReader is a minimal reader of the directory records and the Rock Ridge
+// entries of an image.
+type isoReader struct {
+	t     *testing.T
+	img   []byte
+	files map[string]isoFile
+}
+
+// readISO returns the files of the image, by Rock Ridge path.
+func readISO(t *testing.T, image string) map[string]isoFile {
+	img, err := ioutil.ReadFile(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvd := img[16*sectorSize:]
+	if pvd[0] != 1 || string(pvd[1:6]) != "CD001" {
+		t.Fatal("no primary volume descriptor")
+	}
+	r := &isoReader{t: t, img: img, files: map[string]isoFile{}}
+	root := pvd[156:190]
+	r.readDir("", le32(root[2:]), le32(root[10:]))
+	return r.files
+}
+
+func le32(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *isoReader) readDir(path string, extent, size uint32) {
+	dir := r.img[extent*sectorSize : extent*sectorSize+size]
+	idents := map[string]bool{}
+	for off := uint32(0); off < size; {
+		length := uint32(dir[off])
+		if length == 0 {
+			// Records do not cross sector boundaries
+			off += sectorSize - off%sectorSize
+			continue
+		}
+		rec := dir[off : off+length]
+		off += length
+
+		identLen := int(rec[32])
+		ident := string(rec[33 : 33+identLen])
+		if ident == "\x00" || ident == "\x01" {
+			continue
+		}
+		r.checkIdent(path, ident, rec[25]&2 != 0)
+		if idents[ident] {
+			r.t.Errorf("%s: duplicate identifier %s", path, ident)
+		}
+		idents[ident] = true
+
+		su := 33 + identLen
+		if identLen%2 == 0 {
+			su++
+		}
+		f, name := r.systemUse(rec[su:])
+		p := path + "/" + name
+		switch f.mode & syscall.S_IFMT {
+		case syscall.S_IFDIR:
+			r.readDir(p, le32(rec[2:]), le32(rec[10:]))
+		case syscall.S_IFREG:
+			start := le32(rec[2:]) * sectorSize
+			f.data = string(r.img[start : start+le32(rec[10:])])
+		}
+		r.files[p] = f
+	}
+}
+
+// checkIdent checks that ident is a valid -l identifier: up to 31
+// d-characters, with a dot and a version for files.
+func (r *isoReader) checkIdent(path, ident string, isDir bool) {
+	name := ident
+	if !isDir {
+		if !strings.HasSuffix(name, ";1") || !strings.Contains(name, ".") {
+			r.t.Errorf("%s: bad file identifier %s", path, ident)
+		}
+		name = strings.Replace(strings.TrimSuffix(name, ";1"), ".", "", 1)
+		if len(name) > 30 {
+			r.t.Errorf("%s: identifier %s is too long", path, ident)
+		}
+	} else if len(name) > 31 {
+		r.t.Errorf("%s: identifier %s is too long", path, ident)
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			r.t.Errorf("%s: identifier %s is not made of d-characters", path, ident)
+		}
+	}
+}
+
+// systemUse returns the file and the name of the Rock Ridge entries of su,
+// and of its continuation areas.
+func (r *isoReader) systemUse(su []byte) (isoFile, string) {
+	var f isoFile
+	var name, comp string
+	var comps []string
+	for su != nil {
+		area := su
+		su = nil
+		for len(area) >= 4 && area[2] >= 4 {
+			sig, data := string(area[:2]), area[4:area[2]]
+			area = area[area[2]:]
+			switch sig {
+			case "CE":
+				start := le32(data[0:])*sectorSize + le32(data[8:])
+				su = r.img[start : start+le32(data[16:])]
+			case "PX":
+				f.mode = le32(data[0:])
+			case "NM":
+				name += string(data[1:])
+			case "SL":
+				for c := data[1:]; len(c) > 0; c = c[2+c[1]:] {
+					switch {
+					case c[0]&slRoot != 0:
+						comps = append(comps, "")
+					case c[0]&slCurrent != 0:
+						comps = append(comps, ".")
+					case c[0]&slParent != 0:
+						comps = append(comps, "..")
+					default:
+						comp += string(c[2 : 2+c[1]])
+						if c[0]&slContinue == 0 {
+							comps = append(comps, comp)
+							comp = ""
+						}
+					}
+				}
+			}
+		}
+	}
+	f.target = strings.Join(comps, "/")
+	if f.target == "" && len(comps) == 1 {
+		f.target = "/"
+	}
+	return f, name
+}
+
+func TestWriteISO(t *testing.T) {
+	const (
+		dir  = syscall.S_IFDIR | 0555
+		file = syscall.S_IFREG | 0444
+		exe  = syscall.S_IFREG | 0555
+		link = syscall.S_IFLNK | 0555
+	)
+	longName := strings.Repeat("n", 255)
+	longTarget := "/" + strings.Repeat("t", 300) + "/../" + strings.Repeat("u", 100)
+	large := strings.Repeat("0123456789abcdef", 3*sectorSize/16) + "tail"
+
+	type entry struct {
+		path   string
+		mode   os.FileMode
+		target string
+		data   string
+	}
+	tests := []struct {
+		name  string
+		files []entry
+		want  map[string]isoFile
+	}{
+		{
+			name: "long names",
+			files: []entry{
+				{path: longName, mode: 0644, data: "a"},
+				{path: "file.with.many.dots.and.a.long.extension", mode: 0644},
+				{path: strings.Repeat("same_prefix", 4) + "1.txt", mode: 0644},
+				{path: strings.Repeat("same_prefix", 4) + "2.txt", mode: 0644},
+				{path: strings.Repeat("same-prefix", 4) + "1.txt", mode: 0644},
+			},
+			want: map[string]isoFile{
+				"/" + longName: {mode: file, data: "a"},
+				"/file.with.many.dots.and.a.long.extension":      {mode: file},
+				"/" + strings.Repeat("same_prefix", 4) + "1.txt": {mode: file},
+				"/" + strings.Repeat("same_prefix", 4) + "2.txt": {mode: file},
+				"/" + strings.Repeat("same-prefix", 4) + "1.txt": {mode: file},
+			},
+		},
+		{
+			name: "deep names",
+			files: []entry{
+				{path: strings.Repeat(longName[:100]+"/", 10) + "leaf", mode: 0644, data: "deep"},
+			},
+			want: func() map[string]isoFile {
+				want := map[string]isoFile{}
+				p := ""
+				for i := 0; i < 10; i++ {
+					p += "/" + longName[:100]
+					want[p] = isoFile{mode: dir}
+				}
+				want[p+"/leaf"] = isoFile{mode: file, data: "deep"}
+				return want
+			}(),
+		},
+		{
+			name: "symlinks",
+			files: []entry{
+				{path: "root", target: "/"},
+				{path: "absolute", target: "/usr/lib/libc.so"},
+				{path: "relative", target: "../lib/./libc.so"},
+				{path: "long", target: longTarget},
+			},
+			want: map[string]isoFile{
+				"/root":     {mode: link, target: "/"},
+				"/absolute": {mode: link, target: "/usr/lib/libc.so"},
+				"/relative": {mode: link, target: "../lib/./libc.so"},
+				"/long":     {mode: link, target: longTarget},
+			},
+		},
+		{
+			name: "modes",
+			files: []entry{
+				{path: "private", mode: 0600},
+				{path: "exe", mode: 0700},
+				{path: "group-exe", mode: 0651},
+				{path: "setuid", mode: 0755 | os.ModeSetuid | os.ModeSticky},
+				{path: "dir/", mode: 0700},
+			},
+			want: map[string]isoFile{
+				"/private":   {mode: file},
+				"/exe":       {mode: exe},
+				"/group-exe": {mode: exe},
+				"/setuid":    {mode: exe},
+				"/dir":       {mode: dir},
+			},
+		},
+		{
+			name: "empty files",
+			files: []entry{
+				{path: "empty", mode: 0644},
+				{path: "dir/empty", mode: 0644},
+				{path: "not-empty", mode: 0644, data: "x"},
+			},
+			want: map[string]isoFile{
+				"/empty":     {mode: file},
+				"/dir":       {mode: dir},
+				"/dir/empty": {mode: file},
+				"/not-empty": {mode: file, data: "x"},
+			},
+		},
+		{
+			name: "files of several sectors",
+			files: []entry{
+				{path: "large", mode: 0644, data: large},
+				{path: "after", mode: 0644, data: "after"},
+			},
+			want: map[string]isoFile{
+				"/large": {mode: file, data: large},
+				"/after": {mode: file, data: "after"},
+			},
+		},
+		{
+			name: "excludes",
+			files: []entry{
+				{path: "dev/null", mode: 0644},
+				{path: "usr/proc", mode: 0644},
+				{path: "usr/sys/", mode: 0755},
+				{path: "usr/process", mode: 0644},
+			},
+			want: map[string]isoFile{
+				"/usr":         {mode: dir},
+				"/usr/process": {mode: file},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "nabla-iso")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			root := filepath.Join(tmp, "root")
+
+			// The modes are set last, so that the directories can be
+			// filled first
+			modes := map[string]os.FileMode{root: 0755}
+			for _, e := range tt.files {
+				p := filepath.Join(root, e.path)
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				switch {
+				case e.target != "":
+					err = os.Symlink(e.target, p)
+				case strings.HasSuffix(e.path, "/"):
+					err = os.Mkdir(p, 0755)
+					modes[p] = e.mode
+				default:
+					err = ioutil.WriteFile(p, []byte(e.data), 0644)
+					modes[p] = e.mode
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for p, mode := range modes {
+				if err := os.Chmod(p, mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			image := filepath.Join(tmp, "rootfs.iso")
+			if _, err := writeISO(root, image, nil); err != nil {
+				t.Fatal(err)
+			}
+			got := readISO(t, image)
+			if !reflect.DeepEqual(got, tt.want) {
+				for p, f := range got {
+					if w, ok := tt.want[p]; !ok || w != f {
+						t.Errorf("got  %s: %+v", p, f)
+					}
+				}
+				for p, f := range tt.want {
+					if g, ok := got[p]; !ok || g != f {
+						t.Errorf("want %s: %+v", p, f)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestWriteISOTooLarge checks that a file larger than one extent, which only
+// ISO9660 level 3 can split in several extents, is refused.
+func TestWriteISOTooLarge(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "nabla-iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int64{isoMaxFileSize, isoMaxFileSize + 1} {
+		// A sparse file, its data is never read
+		if err := ioutil.WriteFile(filepath.Join(root, "large"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(filepath.Join(root, "large"), size); err != nil {
+			t.Fatal(err)
+		}
+		w := &isoWriter{inodes: map[[2]uint64]*isoNode{}, slotPaths: map[string]bool{}}
+		n, err := w.walk(root, "", nil)
+		switch {
+		case size == isoMaxFileSize && err != nil:
+			t.Errorf("%d bytes: %v", size, err)
+		case size == isoMaxFileSize && n.children[0].size != isoMaxFileSize:
+			t.Errorf("%d bytes: got a size of %d", size, n.children[0].size)
+		case size > isoMaxFileSize && err == nil:
+			t.Errorf("%d bytes: no error", size)
+		}
+	}
+
+	if _, err := writeISO(root, filepath.Join(tmp, "rootfs.iso"), nil); err == nil {
+		t.Error("writeISO accepted a file larger than one extent")
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(tmp, "rootfs.iso")); !os.IsNotExist(err) {
+		t.Error("writeISO left an image behind")
+	}
+}
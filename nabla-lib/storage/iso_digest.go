@@ -0,0 +1,115 @@
+// Copyright (c) 2018, IBM
+// Author(s): Brandon Lum, Ricardo Koller
+//
+// Permission to use, copy, modify, and/or distribute this software for
+// any purpose with or without fee is hereby granted, provided that the
+// above copyright notice and this permission notice appear in all
+// copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL
+// WARRANTIES WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE
+// AUTHOR BE LIABLE FOR ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL
+// DAMAGES OR ANY DAMAGES WHATSOEVER RESULTING FROM LOSS OF USE, DATA
+// OR PROFITS, WHETHER IN AN ACTION OF CONTRACT, NEGLIGENCE OR OTHER
+// TORTIOUS ACTION, ARISING OUT OF OR IN CONNECTION WITH THE USE OR
+// PERFORMANCE OF THIS SOFTWARE.
+
+// +build linux
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// IsoTreeDigest returns a digest of the tree under dir as CreateIso writes
+// it, so that two trees with the same digest give equivalent ISOs. It covers
+// the names, types, rationalized modes, sizes, modification times, device
+// numbers and symlink targets of the files, and the contents of the regular
+// ones, as reproducible builds pin the modification times. The times of the
+// directories, which change whenever a file is added, are left out, as are
+// the paths in skip, relative to dir.
+func IsoTreeDigest(dir string, skip []string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	skipped := map[string]bool{}
+	for _, p := range skip {
+		skipped[filepath.Join("/", p)] = true
+	}
+
+	h := sha256.New()
+	if err := digestTree(h, absDir, "/", skipped); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func digestTree(h hash.Hash, path, rel string, skipped map[string]bool) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	mode := rationalizeMode(st.Mode)
+
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		fmt.Fprintf(h, "%q %o\n", rel, mode)
+		names, err := readDirNames(path)
+		if err != nil {
+			return err
+		}
+		for _, c := range names {
+			crel := filepath.Join(rel, c)
+			if isoExcluded(c) || skipped[crel] {
+				continue
+			}
+			if err := digestTree(h, filepath.Join(path, c), crel, skipped); err != nil {
+				return err
+			}
+		}
+	case syscall.S_IFLNK:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%q %o %q\n", rel, mode, target)
+	case syscall.S_IFREG:
+		sum, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%q %o %d %d %s\n", rel, mode, fi.Size(),
+			fi.ModTime().UnixNano(), sum)
+	default:
+		fmt.Fprintf(h, "%q %o %d %d %d\n", rel, mode, fi.Size(),
+			fi.ModTime().UnixNano(), st.Rdev)
+	}
+	return nil
+}
+
+// fileDigest returns the sha256 of the contents of the file path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
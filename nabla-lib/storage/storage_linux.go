@@ -20,12 +20,36 @@
 package storage
 
 import (
+	"fmt"
 	"github.com/pkg/errors"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 )
 
+// UseGenisoimageEnv is the environment variable that makes CreateIso use the
+// genisoimage command instead of the native ISO writer, if set.
+const UseGenisoimageEnv = "RUNNC_USE_GENISOIMAGE"
+
+// IsoSlotSize is the largest file a slot can hold.
+const IsoSlotSize = 64 << 10
+
+// IsoSlot is an empty file of an ISO created by CreateIsoWithSlots, with room
+// for IsoSlotSize bytes of data, that WriteIsoSlot fills in a copy of the
+// image. The files that differ between otherwise identical images are
+// slots, so that the rest of the image can be shared.
+type IsoSlot struct {
+	// Path is the path of the file in the image
+	Path string `json:"path"`
+
+	// Record is the offset of the directory record of the file
+	Record int64 `json:"record"`
+
+	// Data is the offset of the data of the file
+	Data int64 `json:"data"`
+}
+
 // CreateDummy creates a dummy file in /tmp
 func CreateDummy() (string, error) {
 	file, err := ioutil.TempFile("/tmp", "nabla")
@@ -62,12 +86,65 @@ func CreateIso(dir string, target *string) (string, error) {
 		return "", errors.Wrap(err, "Unable to resolve abs dir path")
 	}
 
+	if os.Getenv(UseGenisoimageEnv) != "" {
+		if err := createIsoGenisoimage(absDir, fname); err != nil {
+			return "", err
+		}
+		return fname, nil
+	}
+
+	if _, err := writeISO(absDir, fname, nil); err != nil {
+		return "", errors.Wrap(err, "Unable to create iso")
+	}
+
+	return fname, nil
+}
+
+// CreateIsoWithSlots creates an ISO of dir at target, like CreateIso with the
+// native ISO writer, where the files at the paths slots, relative to dir, are
+// empty slots. The directories of the slots must be in dir.
+func CreateIsoWithSlots(dir, target string, slots []string) ([]IsoSlot, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to resolve abs dir path")
+	}
+	ret, err := writeISO(absDir, target, slots)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create iso")
+	}
+	return ret, nil
+}
+
+// WriteIsoSlot writes data to the slot of the ISO image.
+func WriteIsoSlot(image string, slot IsoSlot, data []byte) error {
+	if len(data) > IsoSlotSize {
+		return fmt.Errorf("%s is larger than its slot of %d bytes", slot.Path, IsoSlotSize)
+	}
+	f, err := os.OpenFile(image, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, slot.Data); err != nil {
+		f.Close()
+		return err
+	}
+	// The data length of the record
+	size := make([]byte, 8)
+	both32(size, uint32(len(data)))
+	if _, err := f.WriteAt(size, slot.Record+10); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// createIsoGenisoimage creates the ISO with the genisoimage command
+func createIsoGenisoimage(absDir, fname string) error {
 	cmd := exec.Command("genisoimage", "-m", "dev", "-m", "sys",
 		"-m", "proc", "-l", "-r", "-o", fname, absDir)
-	err = cmd.Run()
+	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", errors.Wrap(err, "Unable to run geniso command")
+		return errors.Wrapf(err, "Unable to run geniso command: %s", out)
 	}
-
-	return fname, nil
+	return nil
 }
@@ -9,11 +9,49 @@ import (
 )
 
 func main() {
-	fsH, err := llfs.NewISOFsHandler()
+	isoFsH, err := llfs.NewISOFsHandler()
 	if err != nil {
 		panic(err)
 	}
-	networkH, err := llnet.NewTapBrNetworkHandler()
+	ext2FsH, err := llfs.NewExt2FsHandler()
+	if err != nil {
+		panic(err)
+	}
+	// The rootfs is a read-only ISO, unless a writable ext2 image is
+	// requested with the rootfs type annotation.
+	fsH, err := llfs.NewSelectFsHandler(map[string]ll.FsHandler{
+		"iso":  isoFsH,
+		"ext2": ext2FsH,
+	}, "iso")
+	if err != nil {
+		panic(err)
+	}
+	tapNetworkH, err := llnet.NewTapBrNetworkHandler()
+	if err != nil {
+		panic(err)
+	}
+	macvtapNetworkH, err := llnet.NewMacvtapNetworkHandler()
+	if err != nil {
+		panic(err)
+	}
+	cniOpts := &llnet.CNIOptions{}
+	cniNetworkH, err := llnet.NewCNINetworkHandler(cniOpts)
+	if err != nil {
+		panic(err)
+	}
+	noopNetworkH, err := llnet.NewNoopNetworkHandler()
+	if err != nil {
+		panic(err)
+	}
+	// The unikernel is attached to the network of the container with a
+	// tap device, unless the network type annotation is "macvtap", "cni"
+	// or "none".
+	networkH, err := llnet.NewSelectNetworkHandler(map[string]ll.NetworkHandler{
+		"tap":     tapNetworkH,
+		"macvtap": macvtapNetworkH,
+		"cni":     cniNetworkH,
+		"none":    noopNetworkH,
+	}, "tap")
 	if err != nil {
 		panic(err)
 	}
@@ -29,6 +67,7 @@ func main() {
 	}
 
 	// We run the OCI runtime called "runnc", with root dir "/run/runnc"
-	// with the low level handlers chosen above.
-	llcli.Runllc("runnc", "/run/runnc", nablaLLCHandler)
+	// with the low level handlers chosen above, and the flags of their
+	// options.
+	llcli.Runllc("runnc", "/run/runnc", nablaLLCHandler, cniOpts.Flags()...)
 }
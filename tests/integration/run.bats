@@ -68,6 +68,23 @@ function runnc_run() {
 	teardown_test
 }
 
+@test "hello without network" {
+	setup_test "hello"
+	local name="test-nabla-hello-nonet"
+
+	config_mod '.process.args |= .+ ["test_hello.nabla"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "none"'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"Hello, World"* ]]
+	[[ "$output" != *"--net="* ]]
+
+	runnc delete --force "$name"
+	teardown_test
+}
+
 @test "hello with arg" {
 	setup_test "hello"
 	local name="test-nabla-hello-arg"
@@ -118,6 +135,248 @@ function runnc_run() {
 	teardown_test
 }
 
+@test "hello with runnc run" {
+	setup_test "hello"
+	local name="test-nabla-hello-run"
+
+	config_mod '.process.args |= .+ ["test_hello.nabla", "hola"]'
+
+	run runnc run --bundle "$TEST_BUNDLE" "$name"
+
+	echo "runnc run (status=$status):" >&2
+	echo "$output" >&2
+
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"Hello, World"* ]]
+	[[ "$output" == *"hola"* ]]
+
+	# run removes the container once it exits
+	run runnc state "$name"
+	[ "$status" -ne 0 ]
+
+	teardown_test
+}
+
+@test "list" {
+	setup_test "node"
+	local name="test-nabla-list"
+	local ns="runnc-test-list"
+
+	sudo ip netns add "$ns"
+	sudo ip link add "${ns:0:8}-h" type veth peer name eth0 netns "$ns"
+	sudo ip -n "$ns" addr add 10.99.0.2/24 dev eth0
+	sudo ip -n "$ns" link set eth0 up
+	sudo ip -n "$ns" route add default via 10.99.0.1
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.linux.namespaces |= .+ [{"type": "network", "path": "/var/run/netns/'"$ns"'"}]'
+
+	runnc_run "${name}" "daemon"
+
+	run runnc list
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == *"ID"*"PID"*"STATUS"*"BUNDLE"*"CREATED"*"TAP"*"IP"* ]]
+	[[ "$output" == *"${name}"* ]]
+
+	run runnc list --quiet
+	[ "$status" -eq 0 ]
+	[[ "$output" == "${name}" ]]
+
+	run bash -c "\"$RUNNC\" --root \"$ROOT\" list --format json | jq -r '.[0].id'"
+	[ "$status" -eq 0 ]
+	[[ "$output" == "${name}" ]]
+
+	# The address is set up in the Run phase, after create
+	run bash -c "\"$RUNNC\" --root \"$ROOT\" list --format json | jq -r '.[0].ip'"
+	[ "$status" -eq 0 ]
+	[[ "$output" == "10.99.0.2" ]]
+
+	runnc delete --force "${name}"
+	sudo ip netns del "$ns"
+	teardown_test
+}
+
+@test "ps" {
+	setup_test "node"
+	local name="test-nabla-ps"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/loop.js"]'
+
+	runnc_run "${name}" "daemon"
+
+	run runnc ps "${name}"
+	[ "$status" -eq 0 ]
+	[[ "${lines[0]}" == *"PID"* ]]
+	[[ "$output" == *"nabla-run"* ]]
+
+	run runnc ps --format json "${name}"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"$(cat "${ROOT}"/pid)"* ]]
+
+	# The unikernels started by exec are listed too
+	runnc exec --detach --pid-file "${ROOT}/exec.pid" "${name}" node.nabla /hello/loop.js
+	run runnc ps --format json "${name}"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"$(cat "${ROOT}"/exec.pid)"* ]]
+
+	runnc delete --force "${name}"
+	teardown_test
+}
+
+@test "events --stats" {
+	setup_test "node"
+	local name="test-nabla-events"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+
+	runnc_run "${name}" "daemon"
+
+	run runnc events --stats "${name}"
+	[ "$status" -eq 0 ]
+	[[ "$output" == *"\"type\":\"stats\""* ]]
+	[[ "$output" == *"\"id\":\"${name}\""* ]]
+	[[ "$output" == *"\"memory\""* ]]
+
+	runnc delete --force "${name}"
+	teardown_test
+}
+
+@test "pause and resume" {
+	setup_test "node"
+	local name="test-nabla-pause"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+
+	runnc_run "${name}" "daemon"
+
+	run runnc pause "${name}"
+	[ "$status" -eq 0 ]
+	run runnc state "${name}"
+	[[ "$output" == *"\"status\": \"paused\""* ]]
+
+	# A paused container can not be deleted without --force
+	run runnc delete "${name}"
+	[ "$status" -ne 0 ]
+
+	run runnc resume "${name}"
+	[ "$status" -eq 0 ]
+	run runnc state "${name}"
+	[[ "$output" == *"\"status\": \"running\""* ]]
+
+	runnc pause "${name}"
+	runnc delete --force "${name}"
+	teardown_test
+}
+
+@test "delete with an exec process" {
+	setup_test "node"
+	local name="test-nabla-delete-exec"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/loop.js"]'
+
+	runnc_run "${name}" "daemon"
+
+	# The additional unikernel is in the cgroup of the container, but not
+	# in the process group of its init
+	runnc exec --detach --pid-file "${ROOT}/exec.pid" "${name}" node.nabla /hello/loop.js
+	local exec_pid=$(cat "${ROOT}/exec.pid")
+	kill -0 "${exec_pid}"
+
+	run runnc delete --force "${name}"
+	[ "$status" -eq 0 ]
+	! kill -0 "${exec_pid}"
+	run runnc state "${name}"
+	[ "$status" -ne 0 ]
+	[ ! -d "${ROOT}/${name}" ]
+
+	teardown_test
+}
+
+@test "update" {
+	setup_test "node"
+	local name="test-nabla-update"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+
+	runnc_run "${name}" "daemon"
+
+	run runnc update --cpu-share 512 --pids-limit 10 "${name}"
+	[ "$status" -eq 0 ]
+	[[ "$(cat "${ROOT}/${name}/state.json")" == *"\"pids_limit\":10"* ]]
+
+	# nabla-run can not change the guest memory of a running unikernel
+	run runnc update --memory 1G "${name}"
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"guest memory"* ]]
+
+	runnc delete --force "${name}"
+	teardown_test
+}
+
+@test "native iso matches genisoimage" {
+	setup_test "node"
+	local name="test-nabla-iso"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	ln -s app.js hello/link.js
+	mkfifo hello/fifo
+	mkdir -p hello/dev hello/a/b/c/d/e/f/g/h/i
+
+	# The second image would come from the ISO cache
+	RUNNC_ISO_CACHE_SIZE=0 runnc create --bundle "$TEST_BUNDLE" "${name}"
+	RUNNC_ISO_CACHE_SIZE=0 RUNNC_USE_GENISOIMAGE=1 runnc create --bundle "$TEST_BUNDLE" "${name}-geniso"
+
+	run bash -c "diff <(isoinfo -R -f -i \"${ROOT}/${name}/rootfs.iso\" | sort) \
+		<(isoinfo -R -f -i \"${ROOT}/${name}-geniso/rootfs.iso\" | sort)"
+	[ "$status" -eq 0 ]
+
+	runnc delete --force "${name}"
+	runnc delete --force "${name}-geniso"
+	teardown_test
+}
+
+@test "iso cache" {
+	setup_test "node"
+	local name="test-nabla-isocache"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod ".mounts |= .+ [{\"destination\": \"/etc/hostname\", \"type\": \"bind\", \"source\": \"$BATS_TMPDIR/hostname\", \"options\": [\"rbind\", \"ro\"]}]"
+
+	echo "${name}-1" > "$BATS_TMPDIR/hostname"
+	runnc create --bundle "$TEST_BUNDLE" "${name}-1"
+	echo "${name}-2" > "$BATS_TMPDIR/hostname"
+	runnc create --bundle "$TEST_BUNDLE" "${name}-2"
+
+	# Both containers use the same cached image, which leaves out the
+	# injected files, and each copy has its own
+	[ "$(find "${ROOT}/.isocache/images" -path "*/refs/${name}-*" | xargs -n1 dirname | sort -u | wc -l)" -eq 1 ]
+	[ "$(find "${ROOT}/.isocache/images" -path "*/refs/${name}-*" | wc -l)" -eq 2 ]
+	[ "$(isoinfo -R -x /etc/hostname -i "${ROOT}/${name}-1/rootfs.iso")" == "${name}-1" ]
+	[ "$(isoinfo -R -x /etc/hostname -i "${ROOT}/${name}-2/rootfs.iso")" == "${name}-2" ]
+	local image="$(dirname "$(find "${ROOT}/.isocache/images" -path "*/refs/${name}-1")")/../rootfs.iso"
+	[ -z "$(isoinfo -R -x /etc/hostname -i "$image")" ]
+
+	# A change of the same size that keeps the modification time, as in
+	# reproducible builds, gives another image
+	touch -r hello/app.js "$BATS_TMPDIR/app.js.mtime"
+	sed -i 's/hello/HELLO/' hello/app.js
+	touch -r "$BATS_TMPDIR/app.js.mtime" hello/app.js
+	runnc create --bundle "$TEST_BUNDLE" "${name}-3"
+	[ "$(find "${ROOT}/.isocache/images" -path "*/refs/${name}-*" | xargs -n1 dirname | sort -u | wc -l)" -eq 2 ]
+	runnc delete --force "${name}-3"
+	rm "$BATS_TMPDIR/app.js.mtime"
+
+	run runnc list --quiet
+	[ "$status" -eq 0 ]
+	[[ "$output" != *".isocache"* ]]
+
+	runnc delete --force "${name}-1"
+	runnc delete --force "${name}-2"
+	[ "$(find "${ROOT}/.isocache/images" -path "*/refs/${name}-*" | wc -l)" -eq 0 ]
+	rm "$BATS_TMPDIR/hostname"
+	teardown_test
+}
+
 @test "hello with net setting" {
 	skip "TODO: Require proper networking for native runnc in prestart hooks"
 }
@@ -160,6 +419,400 @@ function runnc_run() {
 	teardown_test
 }
 
+@test "node hello ext2 rootfs" {
+	setup_test "node"
+	local name="test-nabla-node-ext2"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.rootfs.type"] = "ext2"'
+	config_mod '.annotations["nabla-containers.runnc.rootfs.size"] = "256M"'
+	config_mod '.root.readonly = false'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[ "$(stat -c %s "${ROOT}/${name}/rootfs.ext2")" -eq $((256 << 20)) ]
+
+	runnc delete --force "$name"
+	teardown_test
+}
+
+@test "node hello from volume" {
+	setup_test "node"
+	local name="test-nabla-node-volume"
+	local vol="$BATS_TMPDIR/test-nabla-volume"
+
+	mkdir -p "$vol"
+	echo "read from the volume" > "$vol/volume.txt"
+
+	# The script comes from the rootfs, and reads a file of the volume
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/read.js"]'
+	config_mod ".mounts |= .+ [{\"destination\": \"/vol\", \"type\": \"bind\", \"source\": \"$vol\", \"options\": [\"rbind\", \"ro\"]}]"
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"read from the volume"* ]]
+	# The volume is in the rootfs image, nabla-run takes a single disk
+	[ ! -e "${ROOT}/${name}/volumes" ]
+	[ ! -e "$TEST_BUNDLE/vol/volume.txt" ]
+
+	runnc delete --force "$name"
+	rm -r "$vol"
+	teardown_test
+}
+
+@test "writable volume is synced on delete" {
+	setup_test "node"
+	local name="test-nabla-node-rw-volume"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/vol/write.js"]'
+	config_mod '.annotations["nabla-containers.runnc.rootfs.type"] = "ext2"'
+	config_mod '.root.readonly = false'
+	config_mod ".mounts |= .+ [{\"destination\": \"/vol\", \"type\": \"bind\", \"source\": \"$TEST_BUNDLE/hello\", \"options\": [\"rbind\"]}]"
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[ -f "${ROOT}/${name}/volumes/0.manifest" ]
+	[ ! -e "$TEST_BUNDLE/hello/written.txt" ]
+
+	# The host changes while the container runs are kept
+	echo "// changed on the host" >> "$TEST_BUNDLE/hello/app.js"
+
+	runnc delete --force "$name"
+	# The file written by the unikernel is on the host
+	[[ "$(cat "$TEST_BUNDLE/hello/written.txt")" == "written by node" ]]
+	[[ "$(tail -1 "$TEST_BUNDLE/hello/app.js")" == "// changed on the host" ]]
+	[ ! -e "$TEST_BUNDLE/hello/app.js.nabla-conflict" ]
+	teardown_test
+}
+
+@test "writable volume without manifest is kept" {
+	setup_test "node"
+	local name="test-nabla-node-rw-volume-nomanifest"
+	local kept="${ROOT}/unsynced-volumes/${name}/rootfs.ext2"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/vol/write.js"]'
+	config_mod '.annotations["nabla-containers.runnc.rootfs.type"] = "ext2"'
+	config_mod '.root.readonly = false'
+	config_mod ".mounts |= .+ [{\"destination\": \"/vol\", \"type\": \"bind\", \"source\": \"$TEST_BUNDLE/hello\", \"options\": [\"rbind\"]}]"
+
+	runnc_run "$name"
+
+	# The volume can not be synced, the delete fails with the path of the
+	# rootfs image, which is kept out of the container root
+	rm "${ROOT}/${name}/volumes/0.manifest"
+	run runnc delete --force "$name"
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"$kept"* ]]
+	[ ! -d "${ROOT}/${name}" ]
+	[ ! -e "$TEST_BUNDLE/hello/written.txt" ]
+
+	# The image has what the unikernel wrote
+	run debugfs -R "cat /vol/written.txt" "$kept"
+	[[ "$output" == *"written by node"* ]]
+
+	rm -r "${ROOT}/unsynced-volumes/${name}"
+	teardown_test
+}
+
+@test "readonly rootfs and tmpfs" {
+	setup_test "node"
+	local name="test-nabla-node-tmpfs"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.rootfs.type"] = "ext2"'
+	config_mod '.root.readonly = true'
+	config_mod '.mounts |= .+ [{"destination": "/scratch", "type": "tmpfs", "source": "tmpfs", "options": ["size=64m"]}]'
+
+	# The unikernel has no tmpfs, the mount is ignored instead of failing
+	# the create
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[[ "$output" != *'"tmpfs"'* ]]
+	# The read-only rootfs is an ISO despite the annotation
+	[ -f "${ROOT}/${name}/rootfs.iso" ]
+
+	runnc delete --force "$name"
+	teardown_test
+}
+
+@test "node hello macvtap" {
+	setup_test "node"
+	local name="test-nabla-node-macvtap"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "macvtap"'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[[ "$output" == *"--net=/dev/tap"* ]]
+	[[ "$output" == *"--net-mac="* ]]
+
+	runnc delete --force "$name"
+	[ ! -e "/dev/tap${name:0:12}" ]
+	teardown_test
+}
+
+@test "node hello with master mac" {
+	setup_test "node"
+	local name="test-nabla-node-master-mac"
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.master-mac"] = "02:00:00:00:00:01"'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+
+	runnc delete --force "$name"
+	teardown_test
+}
+
+@test "node hello twice in the same netns" {
+	setup_test "node"
+	local name="test-nabla-node-netns"
+	local ns="runnc-test-netns"
+
+	sudo ip netns add "$ns"
+	sudo ip link add "${ns:0:8}-h" type veth peer name eth0 netns "$ns"
+	sudo ip -n "$ns" addr add 10.99.0.2/24 dev eth0
+	sudo ip -n "$ns" link set eth0 up
+	sudo ip -n "$ns" route add default via 10.99.0.1
+	local mac=$(sudo ip -n "$ns" -br link show eth0 | awk '{print $3}')
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.linux.namespaces |= .+ [{"type": "network", "path": "/var/run/netns/'"$ns"'"}]'
+
+	for i in 1 2; do
+		runnc_run "$name"
+
+		run cat "$TEST_BUNDLE/$RUNNC_OUT"
+		[[ "$output" == *"hello from node"* ]]
+
+		runnc delete --force "$name"
+
+		run sudo ip -n "$ns" addr show eth0
+		[[ "$output" == *"10.99.0.2/24"* ]]
+		[[ "$output" == *"$mac"* ]]
+		run sudo ip -n "$ns" route
+		[[ "$output" == *"default via 10.99.0.1 dev eth0"* ]]
+		run sudo ip -n "$ns" -br link
+		[[ "$output" != *"br"* ]]
+	done
+
+	sudo ip netns del "$ns"
+	teardown_test
+}
+
+@test "node hello cni" {
+	local cni_dir=$(readlink -f "$TESTDATA"/cni)
+	setup_test "node"
+	local name="test-nabla-node-cni"
+	local log="/tmp/runnc-fake-cni.log"
+
+	rm -f "$log"
+	export RUNNC_CNI_PATH="$cni_dir"
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "cni"'
+	config_mod '.annotations["nabla-containers.runnc.network.cni.conflist"] = "'"$cni_dir"'/fake.conflist"'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[[ "$output" == *"10.88.0.2"* ]]
+
+	runnc delete --force "$name"
+	[ ! -e "/var/run/netns/runnc-$name" ]
+	run cat "$log"
+	[[ "${lines[0]}" == "ADD $name /var/run/netns/runnc-$name eth0" ]]
+	[[ "${lines[1]}" == "DEL $name /var/run/netns/runnc-$name eth0" ]]
+	teardown_test
+}
+
+@test "cni plugin failure" {
+	local cni_dir=$(readlink -f "$TESTDATA"/cni)
+	setup_test "node"
+	local name="test-nabla-node-cnifail"
+	local log="/tmp/runnc-fake-cni.log"
+
+	rm -f "$log"
+	jq '.plugins += [.plugins[0] + {"fail": true}]' \
+		"$cni_dir"/fake.conflist > "$TEST_BUNDLE"/fail.conflist
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "cni"'
+
+	# The list comes from the global flags instead of the annotation
+	run runnc --cni-path "$cni_dir" --cni-conflist "$TEST_BUNDLE"/fail.conflist \
+		create --bundle "$TEST_BUNDLE" "$name"
+	[ "$status" -ne 0 ]
+	[[ "$output" == *"fake failure"* ]]
+
+	# The plugin added before the failing one is deleted
+	[ ! -e "/var/run/netns/runnc-$name" ]
+	run cat "$log"
+	[[ "${lines[0]}" == "ADD $name /var/run/netns/runnc-$name eth0" ]]
+	[[ "${lines[1]}" == "ADD $name /var/run/netns/runnc-$name eth0" ]]
+	[[ "${lines[2]}" == "DEL $name /var/run/netns/runnc-$name eth0" ]]
+	[[ "${lines[3]}" == "DEL $name /var/run/netns/runnc-$name eth0" ]]
+	run runnc state "$name"
+	[ "$status" -ne 0 ]
+	teardown_test
+}
+
+@test "node hello cni dual stack" {
+	local cni_dir=$(readlink -f "$TESTDATA"/cni)
+	setup_test "node"
+	local name="test-nabla-node-cni6"
+
+	export RUNNC_CNI_PATH="$cni_dir"
+	jq '.plugins[0] += {"address6": "fd00:88::2/64", "gateway6": "fd00:88::1"}' \
+		"$cni_dir"/fake.conflist > "$TEST_BUNDLE"/dual.conflist
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "cni"'
+	config_mod '.annotations["nabla-containers.runnc.network.cni.conflist"] = "'"$TEST_BUNDLE"'/dual.conflist"'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[[ "$output" == *'"type":"inet","method":"static","addr":"10.88.0.2","mask":"24","gw":"10.88.0.1"'* ]]
+	[[ "$output" == *'"type":"inet6","method":"static","addr":"fd00:88::2","mask":"64","gw":"fd00:88::1"'* ]]
+
+	runnc delete --force "$name"
+	teardown_test
+}
+
+@test "node hello with a second interface" {
+	setup_test "node"
+	local name="test-nabla-node-multi-if"
+	local ns="runnc-test-multi-if"
+
+	sudo ip netns add "$ns"
+	sudo ip link add "${ns:0:8}-h0" type veth peer name eth0 netns "$ns"
+	sudo ip link add "${ns:0:8}-h1" type veth peer name net1 netns "$ns"
+	sudo ip -n "$ns" addr add 10.99.0.2/24 dev eth0
+	sudo ip -n "$ns" addr add 10.98.0.2/24 dev net1
+	sudo ip -n "$ns" link set eth0 up
+	sudo ip -n "$ns" link set net1 up
+	sudo ip -n "$ns" route add default via 10.99.0.1
+
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.linux.namespaces |= .+ [{"type": "network", "path": "/var/run/netns/'"$ns"'"}]'
+
+	# nabla-run only takes one interface, eth0 is handed over and net1 is
+	# left alone
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	[[ "$output" == *'"if":"ukvmif0","cloner":"True","type":"inet","method":"static","addr":"10.99.0.2"'* ]]
+	[[ "$output" != *"ukvmif1"* ]]
+	[[ "$output" != *"10.98.0.2"* ]]
+	run sudo ip -n "$ns" addr show net1
+	[[ "$output" == *"10.98.0.2/24"* ]]
+
+	runnc delete --force "$name"
+	run sudo ip -n "$ns" addr show eth0
+	[[ "$output" == *"10.99.0.2/24"* ]]
+	run sudo ip -n "$ns" addr show net1
+	[[ "$output" == *"10.98.0.2/24"* ]]
+
+	sudo ip netns del "$ns"
+	teardown_test
+}
+
+@test "node hello cni gateways" {
+	local cni_dir=$(readlink -f "$TESTDATA"/cni)
+	setup_test "node"
+	local name="test-nabla-node-cni-gw"
+
+	export RUNNC_CNI_PATH="$cni_dir"
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "cni"'
+	config_mod '.annotations["nabla-containers.runnc.network.cni.conflist"] = "'"$TEST_BUNDLE"'/gw.conflist"'
+
+	# address, gateway, whether the gateway is in the subnet, and the mask
+	# widened to reach it for a unikernel without routes
+	local cases=(
+		"10.88.0.2/32 169.254.1.1 off-link 1"
+		"10.88.0.2/32 10.88.0.1 off-link 30"
+		"10.88.0.2/24 10.88.0.1 on-link 24"
+		"10.88.0.2/24 10.87.0.1 off-link 12"
+		"10.88.0.2/16 10.88.200.1 on-link 16"
+		"10.88.0.2/16 10.90.0.1 off-link 14"
+	)
+	for routes in false true; do
+		config_mod '.annotations["nabla-containers.runnc.guest.routes"] = "'"$routes"'"'
+		for c in "${cases[@]}"; do
+			read address gateway link widened <<< "$c"
+			jq --arg a "$address" --arg g "$gateway" '.plugins[0] += {"address": $a, "gateway": $g}' \
+				"$cni_dir"/fake.conflist > "$TEST_BUNDLE"/gw.conflist
+
+			runnc_run "$name"
+
+			run cat "$TEST_BUNDLE/$RUNNC_OUT"
+			[[ "$output" == *"hello from node"* ]]
+			[[ "$output" != *"WARNING"* ]]
+			local net='"type":"inet","method":"static","addr":"10.88.0.2","mask":"'
+			if [ "$link" == "on-link" ]; then
+				[[ "$output" == *"$net${address#*/}"'","gw":"'"$gateway"'"}'* ]]
+				[[ "$output" != *'"route"'* ]]
+			elif [ "$routes" == "true" ]; then
+				[[ "$output" == *"$net${address#*/}"'"}'* ]]
+				[[ "$output" == *'"route":{"dst":"'"$gateway"'/32","if":"ukvmif0"},"route":{"dst":"0.0.0.0/0","gw":"'"$gateway"'"}'* ]]
+			else
+				[[ "$output" == *"$net$widened"'","gw":"'"$gateway"'"}'* ]]
+				[[ "$output" != *'"route"'* ]]
+			fi
+
+			runnc delete --force "$name"
+		done
+	done
+	teardown_test
+}
+
+@test "node hello cni routes and dns" {
+	local cni_dir=$(readlink -f "$TESTDATA"/cni)
+	setup_test "node"
+	local name="test-nabla-node-cni-routes"
+
+	export RUNNC_CNI_PATH="$cni_dir"
+	jq '.plugins[0] += {
+		"routes": [{"dst": "10.99.0.0/16", "gw": "10.88.0.254"}, {"dst": "10.77.0.0/16"}],
+		"dns": {"nameservers": ["10.88.0.53"], "search": ["example.com"]}
+	}' "$cni_dir"/fake.conflist > "$TEST_BUNDLE"/routes.conflist
+	config_mod '.process.args |= .+ ["node.nabla", "/hello/app.js"]'
+	config_mod '.annotations["nabla-containers.runnc.network.type"] = "cni"'
+	config_mod '.annotations["nabla-containers.runnc.network.cni.conflist"] = "'"$TEST_BUNDLE"'/routes.conflist"'
+	config_mod '.annotations["nabla-containers.runnc.guest.routes"] = "true"'
+
+	runnc_run "$name"
+
+	run cat "$TEST_BUNDLE/$RUNNC_OUT"
+	[[ "$output" == *"hello from node"* ]]
+	# The device routes go first, and the subnet of the address is left out
+	[[ "$output" == *'"route":{"dst":"10.77.0.0/16","if":"ukvmif0"},"route":{"dst":"10.99.0.0/16","gw":"10.88.0.254"}'* ]]
+	[[ "$output" != *'"dst":"10.88.0.0/24"'* ]]
+	[[ "$output" != *'"resolvconf"'* ]]
+	# The dns of the CNI result is in the rootfs
+	run isoinfo -R -x /etc/resolv.conf -i "${ROOT}/${name}/rootfs.iso"
+	[[ "$output" == *"nameserver 10.88.0.53"* ]]
+	[[ "$output" == *"search example.com"* ]]
+
+	runnc delete --force "$name"
+	teardown_test
+}
+
 @test "node env" {
 	setup_test "node"
 	local name="test-nabla-node-env"
@@ -0,0 +1,2 @@
+console.log("looping")
+setInterval(function() {}, 1000)
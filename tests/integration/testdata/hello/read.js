@@ -0,0 +1,2 @@
+var fs = require('fs')
+console.log(fs.readFileSync('/vol/volume.txt', 'utf8'))
@@ -0,0 +1,3 @@
+var fs = require('fs')
+fs.writeFileSync('/vol/written.txt', 'written by node\n')
+console.log("hello from node")